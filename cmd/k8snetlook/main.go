@@ -1,40 +1,70 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/sarun87/k8snetlook/k8snetlook"
 	log "github.com/sarun87/k8snetlook/logutil"
+	"github.com/sarun87/k8snetlook/netutils"
 )
 
 var (
-	podCmd       *flag.FlagSet // Sub-command for pod debugging
-	hostOnlyCmd  *flag.FlagSet // Sub-command for host debugging
-	podDebugging bool          // Variable to hold debug mode
-	debugLogging bool          // Enable debug logging
-	silent       bool          // Output only errors
+	cfg             k8snetlook.Config // Config populated from command line flags
+	podCmd          *flag.FlagSet     // Sub-command for pod debugging
+	hostOnlyCmd     *flag.FlagSet     // Sub-command for host debugging
+	podDebugging    bool              // Variable to hold debug mode
+	debugLogging    bool              // Enable debug logging
+	silent          bool              // Output only errors
+	checks          string            // Comma separated list of checks to run
+	skipChecks      string            // Comma separated list of checks to skip
+	kubeconfigPath  string            // Path to kubeconfig
+	runtimeEndpoint string            // Path to CRI socket
+	checkTimeouts   string            // Comma separated list of name=duration timeout overrides
+	udpPayload      string            // Hex encoded payload sent by UDP checks
 )
 
 func init() {
 	// Pod debugging flags
 	podCmd = flag.NewFlagSet("pod", flag.ExitOnError)
-	podCmd.StringVar(&k8snetlook.Cfg.SrcPod.Name, "srcpodname", "", "Name of source Pod to debug")
-	podCmd.StringVar(&k8snetlook.Cfg.SrcPod.Namespace, "srcpodns", "", "Namespace to which the Pod belongs")
-	podCmd.StringVar(&k8snetlook.Cfg.DstPod.Name, "dstpodname", "", "Name of destination Pod to connect")
-	podCmd.StringVar(&k8snetlook.Cfg.DstPod.Namespace, "dstpodns", "", "Namespace to which the Pod belongs")
-	podCmd.StringVar(&k8snetlook.Cfg.DstSvc.Name, "dstsvcname", "", "Name of detination Service to debug")
-	podCmd.StringVar(&k8snetlook.Cfg.DstSvc.Namespace, "dstsvcns", "", "Namespace to which the Pod belongs")
-	podCmd.StringVar(&k8snetlook.Cfg.ExternalIP, "externalip", "", "External IP to test egress traffic flow")
-	podCmd.StringVar(&k8snetlook.Cfg.KubeconfigPath, "config", os.Getenv("KUBECONFIG"), "Path to Kubeconfig")
+	podCmd.StringVar(&cfg.SrcPod.Name, "srcpodname", "", "Name of source Pod to debug")
+	podCmd.StringVar(&cfg.SrcPod.Namespace, "srcpodns", "", "Namespace to which the Pod belongs")
+	podCmd.StringVar(&cfg.DstPod.Name, "dstpodname", "", "Name of destination Pod to connect")
+	podCmd.StringVar(&cfg.DstPod.Namespace, "dstpodns", "", "Namespace to which the Pod belongs")
+	podCmd.StringVar(&cfg.DstSvc.Name, "dstsvcname", "", "Name of detination Service to debug")
+	podCmd.StringVar(&cfg.DstSvc.Namespace, "dstsvcns", "", "Namespace to which the Pod belongs")
+	podCmd.StringVar(&cfg.ExternalIP, "externalip", "", "External IP to test egress traffic flow")
+	podCmd.StringVar(&cfg.ExternalHost, "externalhost", "", "External host name to resolve using the SrcPod's DNS search list")
+	podCmd.StringVar(&kubeconfigPath, "config", os.Getenv("KUBECONFIG"), "Path to Kubeconfig")
+	podCmd.StringVar(&runtimeEndpoint, "runtime-endpoint", "", "Path to the CRI socket of containerd/cri-o. Defaults based on the pod's container runtime")
 	podCmd.BoolVar(&debugLogging, "debug", false, "Enable debug logging to stdout")
 	podCmd.BoolVar(&silent, "silent", false, "Output only errors to stdout")
+	podCmd.StringVar(&checks, "checks", "", "Comma separated list of checks to run. See list-checks subcommand")
+	podCmd.StringVar(&skipChecks, "skip-checks", "", "Comma separated list of checks to skip")
+	podCmd.DurationVar(&cfg.Timeout, "timeout", k8snetlook.DefaultCheckTimeout, "Time each check is allowed to run for. Also the timeout of requests to the k8s api server")
+	podCmd.StringVar(&checkTimeouts, "check-timeout", "", "Comma separated list of per check timeouts. Eg: dstpod-pmtu=1m,dns-kubernetes=5s")
+	podCmd.StringVar(&cfg.ClusterDomain, "cluster-domain", "", "DNS domain of the cluster. Derived from the SrcPod's resolv.conf if not set")
+	podCmd.IntVar(&cfg.DNSRetries, "dns-retries", 0, "Number of times DNS queries are retried after a timeout. Defaults to 2. Negative disables retries")
+	podCmd.StringVar(&udpPayload, "udp-payload", "", "Hex encoded payload sent by UDP checks. Defaults to a DNS query for port 53 & an empty datagram otherwise")
 
 	hostOnlyCmd = flag.NewFlagSet("host", flag.ExitOnError)
-	hostOnlyCmd.StringVar(&k8snetlook.Cfg.KubeconfigPath, "config", os.Getenv("KUBECONFIG"), "Path to Kubeconfig")
+	hostOnlyCmd.StringVar(&kubeconfigPath, "config", os.Getenv("KUBECONFIG"), "Path to Kubeconfig")
+	hostOnlyCmd.StringVar(&cfg.DstSvc.Name, "dstsvcname", "", "Name of NodePort/LoadBalancer/ExternalName Service to debug")
+	hostOnlyCmd.StringVar(&cfg.DstSvc.Namespace, "dstsvcns", "", "Namespace to which the Service belongs")
+	hostOnlyCmd.StringVar(&cfg.ClusterDomain, "cluster-domain", "", "DNS domain of the cluster. Defaults to cluster.local")
 	hostOnlyCmd.BoolVar(&debugLogging, "debug", false, "Enable debug logging to stdout")
 	hostOnlyCmd.BoolVar(&silent, "silent", false, "Output only errors to stdout. Return result as json")
+	hostOnlyCmd.StringVar(&checks, "checks", "", "Comma separated list of checks to run. See list-checks subcommand")
+	hostOnlyCmd.StringVar(&skipChecks, "skip-checks", "", "Comma separated list of checks to skip")
+	hostOnlyCmd.DurationVar(&cfg.Timeout, "timeout", k8snetlook.DefaultCheckTimeout, "Time each check is allowed to run for. Also the timeout of requests to the k8s api server")
+	hostOnlyCmd.StringVar(&checkTimeouts, "check-timeout", "", "Comma separated list of per check timeouts. Eg: dstpod-pmtu=1m,dns-kubernetes=5s")
 
 }
 
@@ -45,6 +75,35 @@ func printUsage() {
 	fmt.Println("valid subcommands")
 	fmt.Println("  pod       Debug Pod & host networking")
 	fmt.Println("  host      Debug host networking only")
+	fmt.Println("  list-checks  List checks supported by k8snetlook")
+}
+
+func printChecks() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSCOPE\tREQUIRES\tDESCRIPTION")
+	for _, c := range k8snetlook.RegisteredCheckers() {
+		var prereqs []string
+		for _, p := range c.Prerequisites() {
+			prereqs = append(prereqs, string(p))
+		}
+		requires := strings.Join(prereqs, ",")
+		if requires == "" {
+			requires = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Name(), c.Scope(), requires, c.Description())
+	}
+	w.Flush()
+}
+
+// splitList splits a comma separated list of values ignoring empty values
+func splitList(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
 }
 
 func main() {
@@ -61,6 +120,9 @@ func main() {
 		podCmd.Parse(os.Args[2:])
 	case "host":
 		hostOnlyCmd.Parse(os.Args[2:])
+	case "list-checks":
+		printChecks()
+		return
 	default:
 		fmt.Println("'host' or 'pod' subcommand expected")
 		printUsage()
@@ -77,31 +139,78 @@ func main() {
 		logLevel = log.ERROR
 	}
 
-	log.SetLogLevel(logLevel)
-
-	if err := k8snetlook.Init(k8snetlook.Cfg.KubeconfigPath); err != nil {
-		log.Error("Unable to initialize k8snetlook\n")
-		log.Error("%v\n", err)
+	logger := log.New(logLevel)
+
+	// Cancel the run on Ctrl-C. Checks that did not complete are reported as cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// Restore default signal handling so that a second Ctrl-C exits immediately
+		stop()
+	}()
+
+	session, err := k8snetlook.NewSession(ctx, cfg,
+		k8snetlook.WithKubeconfig(kubeconfigPath),
+		k8snetlook.WithLogger(logger),
+		k8snetlook.WithNetnsResolver(k8snetlook.NewNetnsResolver(runtimeEndpoint)))
+	if err != nil {
+		logger.Error("Unable to initialize k8snetlook\n")
+		logger.Error("%v\n", err)
 		return
 	}
-	defer k8snetlook.Cleanup()
+	defer session.Close()
 
-	k8snetlook.RunHostChecks()
-	if podDebugging == true {
-		k8snetlook.RunPodChecks()
-	}
+	report := session.Run(ctx)
 	if silent {
-		fmt.Printf("%s", k8snetlook.GetReportJSON())
+		fmt.Printf("%s", report.JSON())
 		return
 	}
-	k8snetlook.PrintReport()
+	report.Print(logger)
 }
 
 func validateArgs() {
 	fmt.Println("")
-	if podDebugging && (k8snetlook.Cfg.SrcPod.Name == "" || k8snetlook.Cfg.SrcPod.Namespace == "") {
+	if podDebugging && (cfg.SrcPod.Name == "" || cfg.SrcPod.Namespace == "") {
 		fmt.Printf("error: srcpodname flag and srcpodns required for pod debugging\n\n")
 		podCmd.Usage()
 		os.Exit(1)
 	}
+	cfg.Checks = splitList(checks)
+	cfg.SkipChecks = splitList(skipChecks)
+	if err := k8snetlook.ValidateCheckNames(append(cfg.Checks, cfg.SkipChecks...)); err != nil {
+		fmt.Printf("error: %v\n\n", err)
+		os.Exit(1)
+	}
+	var err error
+	if cfg.CheckTimeouts, err = parseCheckTimeouts(checkTimeouts); err != nil {
+		fmt.Printf("error: %v\n\n", err)
+		os.Exit(1)
+	}
+	if udpPayload != "" {
+		if cfg.UDPPayload, err = netutils.ParseHexPayload(udpPayload); err != nil {
+			fmt.Printf("error: -udp-payload: %v\n\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// parseCheckTimeouts parses a comma separated list of name=duration pairs
+func parseCheckTimeouts(s string) (map[string]time.Duration, error) {
+	ret := map[string]time.Duration{}
+	for _, v := range splitList(s) {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid check timeout %q. Expected name=duration", v)
+		}
+		if err := k8snetlook.ValidateCheckNames(parts[:1]); err != nil {
+			return nil, err
+		}
+		timeout, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout for check %s: %v", parts[0], err)
+		}
+		ret[parts[0]] = timeout
+	}
+	return ret, nil
 }
@@ -1,154 +1,291 @@
 package k8snetlook
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"strconv"
 
-	log "github.com/sarun87/k8snetlook/logutil"
 	"github.com/sarun87/k8snetlook/netutils"
 )
 
+func init() {
+	Register(&checker{
+		name:        "gateway-connectivity",
+		description: "Default gateway connectivity check",
+		scope:       ScopeHost | ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return newResult(RunGatewayConnectivityCheck(ctx, env))
+		},
+	})
+	Register(&checker{
+		name:        "kubeapi-service",
+		description: "Kube service IP connectivity check",
+		scope:       ScopeHost | ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return newResult(RunKubeAPIServiceIPConnectivityCheck(ctx, env))
+		},
+	})
+	Register(&checker{
+		name:        "kubeapi-endpoints",
+		description: "Kube API Server Endpoint IP connectivity check",
+		scope:       ScopeHost | ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return newResult(RunKubeAPIEndpointIPConnectivityCheck(ctx, env))
+		},
+	})
+	Register(&checker{
+		name:        "kubeapi-health",
+		description: "Kube API Server health check",
+		scope:       ScopeHost,
+		run: func(ctx context.Context, env *Env) Result {
+			return newResult(RunAPIServerHealthCheck(ctx, env))
+		},
+	})
+	Register(&checker{
+		name:        "dns-kubernetes",
+		description: "DNS lookup check for kubernetes.default",
+		scope:       ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return RunK8sDNSLookupCheck(ctx, env, env.Cfg.dnsServer(), "kubernetes", "default",
+				env.Cfg.KubeAPIService.IP)
+		},
+	})
+	Register(&checker{
+		name:          "dstpod-connectivity",
+		description:   "DstPod connectivity check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstPod},
+		run: func(ctx context.Context, env *Env) Result {
+			res := newResult(RunDstConnectivityCheck(ctx, env, env.Cfg.DstPod.IP))
+			annotatePolicyDenials(ctx, env, &res, []Port{{}})
+			return res
+		},
+	})
+	Register(&checker{
+		name:          "dstpod-pmtu",
+		description:   "pMTU check for DstIP",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstPod},
+		run: func(ctx context.Context, env *Env) Result {
+			return newResult(RunMTUProbeToDstIPCheck(ctx, env, env.Cfg.DstPod.IP))
+		},
+	})
+	Register(&checker{
+		name:          "externalip-connectivity",
+		description:   "ExternalIP connectivity check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqExternalIP},
+		run: func(ctx context.Context, env *Env) Result {
+			return newResult(RunDstConnectivityCheck(ctx, env, env.Cfg.ExternalIP))
+		},
+	})
+	Register(&checker{
+		name:          "externalip-pmtu",
+		description:   "pMTU check for ExternalIP",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqExternalIP},
+		run: func(ctx context.Context, env *Env) Result {
+			return newResult(RunMTUProbeToDstIPCheck(ctx, env, env.Cfg.ExternalIP))
+		},
+	})
+	Register(&checker{
+		name:          "dstsvc-dns",
+		description:   "DNS lookup for DstSvc",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstSvc},
+		run: func(ctx context.Context, env *Env) Result {
+			if env.Cfg.DstSvc.ClusterIP == "None" {
+				return RunHeadlessDNSLookupCheck(ctx, env, env.Cfg.dnsServer(), env.Cfg.DstSvc)
+			}
+			return RunK8sDNSLookupCheck(ctx, env, env.Cfg.dnsServer(), env.Cfg.DstSvc.Name,
+				env.Cfg.DstSvc.Namespace, env.Cfg.DstSvc.ClusterIP)
+		},
+	})
+	Register(&checker{
+		name:          "dstsvc-endpoints",
+		description:   "DstSvc Endpoints connectivity check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstSvc},
+		run: func(ctx context.Context, env *Env) Result {
+			res := newResult(RunDstSvcEndpointsConnectivityCheck(ctx, env, env.Cfg.DstSvc.SvcEndpoints))
+			addNotReadyDetails(&res, env.Cfg.DstSvc)
+			return res
+		},
+	})
+}
+
 // RunGatewayConnectivityCheck checks connectivity to default gw
-func RunGatewayConnectivityCheck() (bool, error) {
-	log.Debug("Sending ICMP message to gw IP:%s", Cfg.HostGatewayIP)
-	pass, err := netutils.SendRecvICMPMessage(Cfg.HostGatewayIP, 64, true)
+func RunGatewayConnectivityCheck(ctx context.Context, env *Env) (bool, error) {
+	env.Log.Debug("Sending ICMP message to gw IP:%s", env.Cfg.HostGatewayIP)
+	pass, err := netutils.SendRecvICMPMessage(ctx, env.Log, env.Cfg.HostGatewayIP, 64, true)
 	if err != nil {
-		log.Debug("  (Failed) Error running RunGatewayConnectivityCheck. Error: %v\n", err)
+		env.Log.Debug("  (Failed) Error running RunGatewayConnectivityCheck. Error: %v\n", err)
 		return false, err
 	}
 	if pass == 0 {
-		log.Debug("  (Passed) Gateway connectivity check completed successfully")
+		env.Log.Debug("  (Passed) Gateway connectivity check completed successfully")
 		return true, nil
 	}
-	log.Debug("  (Failed) Gateway connectivity check failed")
+	env.Log.Debug("  (Failed) Gateway connectivity check failed")
 	return false, nil
 }
 
 // RunDstConnectivityCheck checks connectivity to destination specified by dstIP
-func RunDstConnectivityCheck(dstIP string) (bool, error) {
-	pass, err := netutils.SendRecvICMPMessage(dstIP, 64, true)
+func RunDstConnectivityCheck(ctx context.Context, env *Env, dstIP string) (bool, error) {
+	pass, err := netutils.SendRecvICMPMessage(ctx, env.Log, dstIP, 64, true)
 	if err != nil {
-		log.Debug("  (Failed) Error running connectivity check to %s. Error: %v\n", dstIP, err)
+		env.Log.Debug("  (Failed) Error running connectivity check to %s. Error: %v\n", dstIP, err)
 		return false, err
 	}
 	if pass == 0 {
-		log.Debug("  (Passed) Connectivity check to destination %s completed successfully\n", dstIP)
+		env.Log.Debug("  (Passed) Connectivity check to destination %s completed successfully\n", dstIP)
 		return true, nil
 	}
-	log.Debug("  (Failed) Connectivity check to destination %s failed\n", dstIP)
+	env.Log.Debug("  (Failed) Connectivity check to destination %s failed\n", dstIP)
 	return false, nil
 }
 
 // RunKubeAPIServiceIPConnectivityCheck checks connectivity to K8s api service via clusterIP
-func RunKubeAPIServiceIPConnectivityCheck() (bool, error) {
+func RunKubeAPIServiceIPConnectivityCheck(ctx context.Context, env *Env) (bool, error) {
 	// TODO: Handle secure/non-secure api-servers
 	// HTTP 401 return code is a successful check
-	url := fmt.Sprintf("https://%s", net.JoinHostPort(Cfg.KubeAPIService.IP, strconv.Itoa(int(Cfg.KubeAPIService.Port))))
+	addr, err := kubeAPIAddr(env.Cfg.KubeAPIService)
+	if err != nil {
+		return false, err
+	}
+	url := fmt.Sprintf("https://%s", addr)
 	var body []byte
-	responseCode, err := netutils.SendRecvHTTPMessage(url, "", &body)
+	responseCode, err := netutils.SendRecvHTTPMessage(ctx, url, "", &body)
 	if err != nil {
-		log.Debug("  (Failed) Error running RunKubeAPIServiceIPConnectivityCheck. Error: %v\n", err)
+		env.Log.Debug("  (Failed) Error running RunKubeAPIServiceIPConnectivityCheck. Error: %v\n", err)
 		return false, err
 	}
 	if responseCode == http.StatusUnauthorized {
-		log.Debug("  (Passed) Kube API Service IP connectivity check completed successfully")
+		env.Log.Debug("  (Passed) Kube API Service IP connectivity check completed successfully")
 	} else {
-		log.Debug("  (Passed) Kube API Service IP connectivity check returned a non 401 HTTP Code")
+		env.Log.Debug("  (Passed) Kube API Service IP connectivity check returned a non 401 HTTP Code")
 	}
 	return true, nil
 }
 
 // RunKubeAPIEndpointIPConnectivityCheck checks connectivity to k8s api server via each endpoint (nodeIP)
-func RunKubeAPIEndpointIPConnectivityCheck() (bool, error) {
+func RunKubeAPIEndpointIPConnectivityCheck(ctx context.Context, env *Env) (bool, error) {
 	// TODO: Handle secure/non-secure api-servers
 	// HTTP 401 return code is a successful check
-	endpoints := getEndpointsFromService("default", "kubernetes")
+	endpoints, _ := splitEndpoints(env.getEndpointsFromService(ctx, "default", "kubernetes"))
 	totalCount := len(endpoints)
 	if totalCount == 0 {
 		return false, fmt.Errorf("could not fetch endpoints for k8s api server")
 	}
 	passedCount := 0
 	for _, ep := range endpoints {
-		url := fmt.Sprintf("https://%s", net.JoinHostPort(ep.IP, strconv.Itoa(int(ep.Port))))
-		log.Debug("  checking endpoint: %s ........", url)
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		addr, err := kubeAPIAddr(ep)
+		if err != nil {
+			env.Log.Debug("  skipping endpoint %s. Error: %v\n", ep.IP, err)
+			continue
+		}
+		url := fmt.Sprintf("https://%s", addr)
+		env.Log.Debug("  checking endpoint: %s ........", url)
 		var body []byte
-		responseCode, err := netutils.SendRecvHTTPMessage(url, "", &body)
+		responseCode, err := netutils.SendRecvHTTPMessage(ctx, url, "", &body)
 		if err != nil {
-			log.Debug("    failed connectivity check. Error: %v\n", err)
+			env.Log.Debug("    failed connectivity check. Error: %v\n", err)
 			continue
 		}
 		if responseCode == http.StatusUnauthorized {
-			log.Debug("    passed connectivity check")
+			env.Log.Debug("    passed connectivity check")
 		} else {
-			log.Debug("    passed connectivity check. Retured non 401 code though")
+			env.Log.Debug("    passed connectivity check. Retured non 401 code though")
 		}
 		passedCount++
 	}
 	if passedCount == totalCount {
-		log.Debug("  (Passed) Kube API Endpoint IP connectivity check")
+		env.Log.Debug("  (Passed) Kube API Endpoint IP connectivity check")
 		return true, nil
 	}
-	log.Debug("  (Failed) Kube API Endoint IP connectivity check for one or more endpoints")
+	env.Log.Debug("  (Failed) Kube API Endoint IP connectivity check for one or more endpoints")
 	return false, nil
 }
 
+// kubeAPIAddr returns the "ip:port" of the https port of the k8s api service or endpoint
+func kubeAPIAddr(ep Endpoint) (string, error) {
+	port, found := ep.lookupPort("https")
+	if !found {
+		return "", fmt.Errorf("no ports found for k8s api server %s", ep.IP)
+	}
+	return net.JoinHostPort(ep.IP, strconv.Itoa(int(port.Port))), nil
+}
+
 // RunAPIServerHealthCheck checks api server health using livez endpoint
-func RunAPIServerHealthCheck() (bool, error) {
-	url := fmt.Sprintf("https://%s/livez?verbose", net.JoinHostPort(Cfg.KubeAPIService.IP, strconv.Itoa(int(Cfg.KubeAPIService.Port))))
-	svcAccountToken, err := getSvcAccountToken()
+func RunAPIServerHealthCheck(ctx context.Context, env *Env) (bool, error) {
+	addr, err := kubeAPIAddr(env.Cfg.KubeAPIService)
+	if err != nil {
+		return false, err
+	}
+	url := fmt.Sprintf("https://%s/livez?verbose", addr)
+	svcAccountToken, err := env.getSvcAccountToken(ctx)
 	if err != nil {
-		log.Debug("  (Failed) ", err)
+		env.Log.Debug("  (Failed) ", err)
 		return false, err
 	}
 	var body []byte
-	responseCode, err := netutils.SendRecvHTTPMessage(url, svcAccountToken, &body)
+	responseCode, err := netutils.SendRecvHTTPMessage(ctx, url, svcAccountToken, &body)
 	if err != nil {
-		log.Debug("    Unable to fetch api server check. Error: %v\n", err)
+		env.Log.Debug("    Unable to fetch api server check. Error: %v\n", err)
 		return false, err
 	}
 	if responseCode != http.StatusOK {
-		log.Debug("  (Failed) status check returned non-200 http code of %d\n", responseCode)
+		env.Log.Debug("  (Failed) status check returned non-200 http code of %d\n", responseCode)
 		return false, nil
 	}
-	log.Debug("%s", body)
-	log.Debug("  (Passed) please check above statuses for (ok)")
+	env.Log.Debug("%s", body)
+	env.Log.Debug("  (Passed) please check above statuses for (ok)")
 	return true, nil
 }
 
-// RunK8sDNSLookupCheck checks DNS lookup functionality for a given K8s service
-func RunK8sDNSLookupCheck(dnsServerIP, dstSvcName, dstSvcNamespace, dstSvcExpectedIP string) (bool, error) {
+// RunK8sDNSLookupCheck checks DNS lookup functionality for a given K8s service. The result
+// & latency of the A & AAAA queries are reported separately
+func RunK8sDNSLookupCheck(ctx context.Context, env *Env, dnsServerIP, dstSvcName, dstSvcNamespace, dstSvcExpectedIP string) Result {
+	var res Result
 	dnsServerURL := net.JoinHostPort(dnsServerIP, "53")
-	// TODO: Fetch domain information from cluster
-	svcfqdn := fmt.Sprintf("%s.%s.svc.cluster.local.", dstSvcName, dstSvcNamespace)
-	ips, err := netutils.RunDNSLookupUsingCustomResolver(dnsServerURL, svcfqdn)
-	if err != nil {
-		log.Debug("  (Failed) Unable to run dns lookup to %s, error: %v\n", svcfqdn, err)
-		return false, err
+	svcfqdn := env.Cfg.serviceFQDN(dstSvcName, dstSvcNamespace)
+	lookup := env.lookupHost(ctx, dnsServerURL, svcfqdn)
+	res.addLookupDetails(lookup)
+	if err := lookup.Err(); err != nil {
+		env.Log.Debug("  (Failed) Unable to run dns lookup to %s, error: %v\n", svcfqdn, err)
+		res.Err = err
+		return res
 	}
 	// Check if the resolved IP matches with the IP reported by K8s
+	ips := lookup.IPs()
 	for _, ip := range ips {
-		if ip == dstSvcExpectedIP {
-			log.Debug("  (Passed) dns lookup to %s returned: %s. Expected: %s\n", svcfqdn, ip, dstSvcExpectedIP)
-			return true, nil
+		if normalizeIP(ip) == normalizeIP(dstSvcExpectedIP) {
+			env.Log.Debug("  (Passed) dns lookup to %s returned: %s. Expected: %s\n", svcfqdn, ip, dstSvcExpectedIP)
+			res.Success = true
+			return res
 		}
 	}
-	log.Debug("  (Failed) Lookup of %s retured: %v, expected: %s\n", svcfqdn, ips, dstSvcExpectedIP)
-	return false, nil
+	env.Log.Debug("  (Failed) Lookup of %s retured: %v, expected: %s\n", svcfqdn, ips, dstSvcExpectedIP)
+	res.addDetail("expected %s", dstSvcExpectedIP)
+	return res
 }
 
 // RunMTUProbeToDstIPCheck checks path-MTU by probing the traffic path using icmp messages
-func RunMTUProbeToDstIPCheck(dstIP string) (bool, error) {
-	supportedMTU, err := netutils.PMTUProbeToDestIP(dstIP)
+func RunMTUProbeToDstIPCheck(ctx context.Context, env *Env, dstIP string) (bool, error) {
+	supportedMTU, err := netutils.PMTUProbeToDestIP(ctx, env.Log, dstIP)
 	if err != nil {
-		log.Debug("   (Failed) Unable to run pmtud for %s. Error: %v\n", dstIP, err)
+		env.Log.Debug("   (Failed) Unable to run pmtud for %s. Error: %v\n", dstIP, err)
 		return false, err
 	}
-	log.Debug("   Maximum MTU that works for destination IP: %s is %d\n", dstIP, supportedMTU)
+	env.Log.Debug("   Maximum MTU that works for destination IP: %s is %d\n", dstIP, supportedMTU)
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		log.Debug("   Unable to fetch network interfaces. Error: %v\n", err)
+		env.Log.Debug("   Unable to fetch network interfaces. Error: %v\n", err)
 		return false, err
 	}
 	for _, iface := range ifaces {
@@ -157,38 +294,41 @@ func RunMTUProbeToDstIPCheck(dstIP string) (bool, error) {
 			continue
 		}
 		if iface.MTU > supportedMTU {
-			log.Debug("  Iface %s has higher mtu than supported path mtu. Has: %d, should be less than %d\n", iface.Name, iface.MTU, supportedMTU)
+			env.Log.Debug("  Iface %s has higher mtu than supported path mtu. Has: %d, should be less than %d\n", iface.Name, iface.MTU, supportedMTU)
 		}
 	}
 	// TODO: Check for the outgoing interface mtu and compare
-	log.Info("   (Passed) Retured MTU for destination IP: %s = %d\n", dstIP, supportedMTU)
+	env.Log.Info("   (Passed) Retured MTU for destination IP: %s = %d\n", dstIP, supportedMTU)
 	return true, nil
 }
 
 // RunDstSvcEndpointsConnectivityCheck checks connectivity from SrcPod to all IPs provided to this checker
-func RunDstSvcEndpointsConnectivityCheck(endpoints []Endpoint) (bool, error) {
+func RunDstSvcEndpointsConnectivityCheck(ctx context.Context, env *Env, endpoints []Endpoint) (bool, error) {
 	totalCount := len(endpoints)
 	if totalCount == 0 {
-		return false, fmt.Errorf("could not fetch endpoints for k8s api server")
+		return false, fmt.Errorf("no ready endpoints found for service")
 	}
 	passedCount := 0
 	for _, ep := range endpoints {
-		log.Debug("  checking endpoint: %s ........", ep.IP)
-		pass, err := netutils.SendRecvICMPMessage(ep.IP, 64, true)
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		env.Log.Debug("  checking endpoint: %s ........", ep.IP)
+		pass, err := netutils.SendRecvICMPMessage(ctx, env.Log, ep.IP, 64, true)
 		if err != nil {
-			log.Debug("  (Failed) Error running connectivity check to %s. Error: %v\n", ep.IP, err)
+			env.Log.Debug("  (Failed) Error running connectivity check to %s. Error: %v\n", ep.IP, err)
 		}
 		if pass == 0 {
-			log.Debug("  (Passed) Connectivity check to destination %s completed successfully\n", ep.IP)
+			env.Log.Debug("  (Passed) Connectivity check to destination %s completed successfully\n", ep.IP)
 			passedCount++
 		} else {
-			log.Debug("  (Failed) Connectivity check to destination %s failed\n", ep.IP)
+			env.Log.Debug("  (Failed) Connectivity check to destination %s failed\n", ep.IP)
 		}
 	}
 	if passedCount == totalCount {
-		log.Debug("  (Passed) DstSvc Endpoints IP connectivity check")
+		env.Log.Debug("  (Passed) DstSvc Endpoints IP connectivity check")
 		return true, nil
 	}
-	log.Debug("  (Failed) DstSvc Endoints IP connectivity check for one or more endpoints")
+	env.Log.Debug("  (Failed) DstSvc Endoints IP connectivity check for one or more endpoints")
 	return false, nil
 }
@@ -0,0 +1,183 @@
+package k8snetlook
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/sarun87/k8snetlook/netutils"
+)
+
+// conntrackUsageThreshold is the fraction of nf_conntrack_max above which new flows are at
+// risk of being dropped when the table fills up
+const conntrackUsageThreshold = 0.9
+
+func init() {
+	Register(&checker{
+		name:        "conntrack",
+		description: "Conntrack entries of probed flows & table usage check",
+		scope:       ScopeHost | ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return RunConntrackCheck(ctx, env)
+		},
+		runLast: true,
+	})
+}
+
+// probeFlow is a flow opened by a checker to probe connectivity
+type probeFlow struct {
+	check string
+	scope Scope
+	flow  netutils.Flow
+	// expectReply is set if the peer must have replied for the probe to pass. Not set
+	// for UDP datagrams that services may ignore
+	expectReply bool
+}
+
+// flowRecorder records the flows opened by checkers so that their conntrack entries can
+// be inspected. Safe for concurrent use
+type flowRecorder struct {
+	mu    sync.Mutex
+	flows []probeFlow
+}
+
+// recordFlow records a flow opened by the running checker from localAddr to remoteAddr
+func (e *Env) recordFlow(protocol, localAddr, remoteAddr string, expectReply bool) {
+	if e.flows == nil || localAddr == "" {
+		return
+	}
+	flow, err := netutils.NewFlow(protocol, localAddr, remoteAddr)
+	if err != nil {
+		e.Log.Debug("Unable to record flow %s -> %s. Error: %v", localAddr, remoteAddr, err)
+		return
+	}
+	e.flows.mu.Lock()
+	defer e.flows.mu.Unlock()
+	e.flows.flows = append(e.flows.flows, probeFlow{check: e.running, scope: e.Scope, flow: flow, expectReply: expectReply})
+}
+
+// recordDNSQuery records the flow of the last attempt of a DNS query sent to nameserver
+func (e *Env) recordDNSQuery(nameserver string, q netutils.DNSQueryResult) {
+	protocol := "UDP"
+	if q.Truncated {
+		protocol = "TCP"
+	}
+	e.recordFlow(protocol, q.LocalAddr, nameserver, true)
+}
+
+// recordedFlows returns the flows recorded in scope
+func (e *Env) recordedFlows(scope Scope) []probeFlow {
+	if e.flows == nil {
+		return nil
+	}
+	e.flows.mu.Lock()
+	defer e.flows.mu.Unlock()
+	var ret []probeFlow
+	for _, f := range e.flows.flows {
+		if f.scope == scope {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// RunConntrackCheck looks up the host conntrack entries of the flows opened by the other
+// checkers of the scope. The endpoint each ClusterIP flow was DNAT'ed to is reported.
+// Fails if a flow was DNAT'ed to an address that isn't a ready endpoint, eg: stale UDP
+// entries after CoreDNS pods are replaced, if a flow that must be replied to is UNREPLIED
+// or if the conntrack table of the host is close to full
+func RunConntrackCheck(ctx context.Context, env *Env) Result {
+	var res Result
+	res.Success = true
+	if count, max, err := netutils.ConntrackUsage(env.HostNsHandle, defaultProcRoot); err != nil {
+		res.addDetail("table usage: unknown: %v", err)
+	} else if max > 0 {
+		res.addDetail("table usage: %d/%d entries (%.1f%%)", count, max, float64(count)*100/float64(max))
+		if float64(count) >= conntrackUsageThreshold*float64(max) {
+			res.addDetail("table is close to nf_conntrack_max. New flows are dropped once it is full")
+			res.Success = false
+		}
+	}
+
+	flows := env.recordedFlows(env.Scope)
+	if len(flows) == 0 {
+		res.addDetail("no flows were probed by the other checks")
+		return res
+	}
+	var tuples []netutils.Flow
+	for _, f := range flows {
+		tuples = append(tuples, f.flow)
+	}
+	entries, err := netutils.LookupConntrack(env.HostNsHandle, tuples)
+	if err != nil {
+		res.Success = false
+		res.Err = err
+		return res
+	}
+	backends := env.serviceBackends(ctx)
+	for _, f := range flows {
+		entry, found := entries[f.flow]
+		if !checkConntrackEntry(&res, f, entry, found, backends) {
+			res.Success = false
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) conntrack entries of %d probed flows\n", len(flows))
+	} else {
+		env.Log.Debug("  (Failed) conntrack entries of %d probed flows\n", len(flows))
+	}
+	return res
+}
+
+// serviceBackends returns the IPs of the ready endpoints of kube-dns, kube-apiserver &
+// DstSvc keyed by ClusterIP
+func (e *Env) serviceBackends(ctx context.Context) map[string]map[string]bool {
+	ret := map[string]map[string]bool{}
+	add := func(clusterIP string, endpoints []Endpoint) {
+		if net.ParseIP(clusterIP) == nil {
+			return
+		}
+		ips := map[string]bool{}
+		for _, ep := range endpoints {
+			ips[normalizeIP(ep.IP)] = true
+		}
+		ret[normalizeIP(clusterIP)] = ips
+	}
+	if e.Cfg.KubeDNSService.IP != "" {
+		dnsEndpoints, _ := splitEndpoints(e.getEndpointsFromService(ctx, "kube-system", "kube-dns"))
+		add(e.Cfg.KubeDNSService.IP, dnsEndpoints)
+	}
+	apiEndpoints, _ := splitEndpoints(e.getEndpointsFromService(ctx, "default", "kubernetes"))
+	add(e.Cfg.KubeAPIService.IP, apiEndpoints)
+	for _, clusterIP := range e.Cfg.DstSvc.clusterIPs() {
+		add(clusterIP, e.Cfg.DstSvc.SvcEndpoints)
+	}
+	return ret
+}
+
+// checkConntrackEntry adds the conntrack entry of the probed flow to res. backends are the
+// ready endpoint IPs of services keyed by ClusterIP. Returns false if the flow was DNAT'ed
+// to an address that isn't a ready endpoint or is UNREPLIED although a reply was expected
+func checkConntrackEntry(res *Result, f probeFlow, entry netutils.ConntrackEntry, found bool, backends map[string]map[string]bool) bool {
+	if !found {
+		res.addDetail("%s: %s: no entry. Expired or not tracked", f.check, f.flow)
+		return true
+	}
+	ok := true
+	detail := f.flow.String()
+	if entry.DNATed() {
+		detail += " DNAT -> " + entry.ReplySrc()
+	}
+	if entry.Unreplied() {
+		detail += " [UNREPLIED]"
+		if f.expectReply {
+			ok = false
+		}
+	}
+	res.addDetail("%s: %s", f.check, detail)
+	if ready, isService := backends[normalizeIP(f.flow.DstIP)]; isService && entry.DNATed() && !ready[normalizeIP(entry.ReplySrcIP)] {
+		res.addDetail("%s: %s: DNAT'ed to %s which isn't a ready endpoint. Entry is stale", f.check, f.flow, entry.ReplySrc())
+		ok = false
+	}
+	return ok
+}
@@ -0,0 +1,683 @@
+package k8snetlook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sarun87/k8snetlook/netutils"
+)
+
+func init() {
+	Register(&checker{
+		name:        "dns-nameserver",
+		description: "SrcPod nameserver is kube-dns check",
+		scope:       ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return RunPodNameserverCheck(ctx, env)
+		},
+	})
+	Register(&checker{
+		name:        "dns-edns",
+		description: "DNS EDNS0 buffer size check against SrcPod nameserver",
+		scope:       ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return RunEDNSBufferSizeCheck(ctx, env, env.Cfg.dnsServer())
+		},
+	})
+	Register(&checker{
+		name:        "dns-replicas",
+		description: "DNS lookup check against each kube-dns replica",
+		scope:       ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return RunDNSReplicasCheck(ctx, env)
+		},
+	})
+	Register(&checker{
+		name:          "dns-nodelocal",
+		description:   "NodeLocal DNSCache & upstream kube-dns check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqNodeLocalDNS},
+		run: func(ctx context.Context, env *Env) Result {
+			return RunNodeLocalDNSCheck(ctx, env)
+		},
+	})
+	Register(&checker{
+		name:          "dstsvc-dns-records",
+		description:   "DstSvc SRV & PTR records check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstSvc},
+		run: func(ctx context.Context, env *Env) Result {
+			return RunServiceDNSRecordsCheck(ctx, env, env.Cfg.dnsServer(), env.Cfg.DstSvc)
+		},
+	})
+	Register(&checker{
+		name:          "dns-search-path",
+		description:   "DNS search list expansion cost for ExternalHost",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqExternalHost},
+		run: func(ctx context.Context, env *Env) Result {
+			return RunSearchPathResolutionCheck(ctx, env, env.Cfg.ExternalHost)
+		},
+	})
+}
+
+// serviceFQDN returns the fully qualified domain name of the service
+func (c *Config) serviceFQDN(name, namespace string) string {
+	domain := c.ClusterDomain
+	if domain == "" {
+		domain = defaultClusterDomain
+	}
+	return fmt.Sprintf("%s.%s.svc.%s.", name, namespace, domain)
+}
+
+// dnsServer returns the nameserver DNS checks are run against. The first nameserver of
+// the SrcPod is used if known so that checks match the pod's behaviour. Falls back to kube-dns
+func (c *Config) dnsServer() string {
+	if c.SrcPod.DNSConfig != nil && len(c.SrcPod.DNSConfig.Nameservers) > 0 {
+		return c.SrcPod.DNSConfig.Nameservers[0]
+	}
+	return c.KubeDNSService.IP
+}
+
+// dnsOptions returns the options used to send DNS queries
+func (c *Config) dnsOptions() netutils.DNSOptions {
+	opts := netutils.DefaultDNSOptions()
+	if c.DNSRetries > 0 {
+		opts.Retries = c.DNSRetries
+	} else if c.DNSRetries < 0 {
+		opts.Retries = 0
+	}
+	return opts
+}
+
+// lookupHost looks up the A & AAAA records of name using the DNS options of the config.
+// The flows of the queries are recorded for the conntrack check
+func (e *Env) lookupHost(ctx context.Context, nameserver, name string) netutils.DNSLookupResult {
+	lookup := netutils.LookupHost(ctx, nameserver, name, e.Cfg.dnsOptions())
+	e.recordDNSQuery(nameserver, lookup.A)
+	e.recordDNSQuery(nameserver, lookup.AAAA)
+	return lookup
+}
+
+// queryDNS sends a query of type qtype for name using the DNS options of the config. The
+// flow of the query is recorded for the conntrack check
+func (e *Env) queryDNS(ctx context.Context, nameserver, name string, qtype uint16) netutils.DNSQueryResult {
+	query := netutils.QueryDNS(ctx, nameserver, name, qtype, e.Cfg.dnsOptions())
+	e.recordDNSQuery(nameserver, query)
+	return query
+}
+
+// lookupAddr looks up the PTR record of ip using the DNS options of the config. The flow
+// of the query is recorded for the conntrack check
+func (e *Env) lookupAddr(ctx context.Context, nameserver, ip string) netutils.DNSQueryResult {
+	query := netutils.LookupAddr(ctx, nameserver, ip, e.Cfg.dnsOptions())
+	e.recordDNSQuery(nameserver, query)
+	return query
+}
+
+// lookupSRV looks up the _service._proto.name SRV records using the DNS options of the
+// config. The flow of the query is recorded for the conntrack check
+func (e *Env) lookupSRV(ctx context.Context, nameserver, service, proto, name string) netutils.DNSQueryResult {
+	query := netutils.LookupSRV(ctx, nameserver, service, proto, name, e.Cfg.dnsOptions())
+	e.recordDNSQuery(nameserver, query)
+	return query
+}
+
+// addLookupDetails adds the outcome of the A & AAAA queries of a lookup to the details
+func (r *Result) addLookupDetails(lookup netutils.DNSLookupResult) {
+	for _, q := range []netutils.DNSQueryResult{lookup.A, lookup.AAAA} {
+		r.addDetail("%s", formatDNSQuery(q))
+	}
+}
+
+// formatDNSQuery returns a single line description of the outcome of a DNS query
+func formatDNSQuery(q netutils.DNSQueryResult) string {
+	var outcome string
+	switch {
+	case q.Err != nil:
+		outcome = q.Err.Error()
+	case len(q.Answers) == 0:
+		outcome = "no records"
+	default:
+		outcome = strings.Join(q.Answers, ", ")
+	}
+	line := fmt.Sprintf("%s %s: %s in %v", q.Name, q.Type, outcome, q.Latency)
+	if q.Attempts > 1 {
+		line += fmt.Sprintf(", %d attempts", q.Attempts)
+	}
+	if q.Truncated {
+		line += ", truncated over UDP & retried over TCP"
+	}
+	return line
+}
+
+// RunPodNameserverCheck checks if the SrcPod resolv.conf points to the kube-dns ClusterIP.
+// Pods with dnsPolicy Default/None use other nameservers. Pods using NodeLocal DNSCache also pass
+func RunPodNameserverCheck(ctx context.Context, env *Env) Result {
+	var res Result
+	conf := env.Cfg.SrcPod.DNSConfig
+	if conf == nil {
+		res.Err = fmt.Errorf("unable to read /etc/resolv.conf of SrcPod")
+		return res
+	}
+	res.addDetail("nameservers: %s", strings.Join(conf.Nameservers, ", "))
+	res.addDetail("search: %s", strings.Join(conf.Search, " "))
+	res.addDetail("ndots: %d", conf.Ndots)
+	res.addDetail("cluster domain: %s", env.Cfg.ClusterDomain)
+	if env.Cfg.KubeDNSService.IP == "" {
+		res.Err = fmt.Errorf("kube-dns service not found")
+		return res
+	}
+	for _, nameserver := range conf.Nameservers {
+		if normalizeIP(nameserver) == normalizeIP(env.Cfg.KubeDNSService.IP) {
+			env.Log.Debug("  (Passed) pod nameserver is kube-dns %s\n", nameserver)
+			res.Success = true
+			return res
+		}
+	}
+	if containsAnyIP(conf.Nameservers, env.Cfg.NodeLocalDNS.IPs) {
+		env.Log.Debug("  (Passed) pod nameserver is NodeLocal DNSCache %v\n", conf.Nameservers)
+		res.addDetail("pod nameserver is the NodeLocal DNSCache. See dns-nodelocal check")
+		res.Success = true
+		return res
+	}
+	res.addDetail("pod nameservers differ from kube-dns ClusterIP %s. Pod may use dnsPolicy Default/None or a node local DNS cache",
+		env.Cfg.KubeDNSService.IP)
+	env.Log.Debug("  (Failed) pod nameservers %v differ from kube-dns %s\n", conf.Nameservers, env.Cfg.KubeDNSService.IP)
+	return res
+}
+
+// RunHeadlessDNSLookupCheck checks DNS records of a headless service. The A/AAAA records of
+// the service must match the IPs of the ready endpoints. Endpoints with a hostname, eg:
+// StatefulSet pods, must also resolve using <hostname>.<svc>.<ns>.svc.<domain>
+func RunHeadlessDNSLookupCheck(ctx context.Context, env *Env, dnsServerIP string, svc Service) Result {
+	var res Result
+	dnsServerURL := net.JoinHostPort(dnsServerIP, "53")
+	endpoints := svc.SvcEndpoints
+	if svc.PublishNotReadyAddresses {
+		endpoints = append(append([]Endpoint{}, endpoints...), svc.NotReadyEndpoints...)
+	}
+	expected := map[string]bool{}
+	for _, ep := range endpoints {
+		expected[normalizeIP(ep.IP)] = true
+	}
+
+	svcfqdn := env.Cfg.serviceFQDN(svc.Name, svc.Namespace)
+	lookup := env.lookupHost(ctx, dnsServerURL, svcfqdn)
+	ips, err := lookup.IPs(), lookup.Err()
+	if err != nil && len(expected) > 0 {
+		env.Log.Debug("  (Failed) Unable to run dns lookup to %s, error: %v\n", svcfqdn, err)
+		res.Err = err
+		return res
+	}
+	resolved := map[string]bool{}
+	for _, ip := range ips {
+		resolved[normalizeIP(ip)] = true
+	}
+	missing, extra := diffIPSets(expected, resolved)
+	res.Success = len(missing) == 0 && len(extra) == 0
+	res.addDetail("%s: %d records, %d endpoints", svcfqdn, len(resolved), len(expected))
+	if len(missing) > 0 {
+		res.addDetail("%s: endpoints missing from DNS: %s", svcfqdn, strings.Join(missing, ", "))
+	}
+	if len(extra) > 0 {
+		res.addDetail("%s: records that are not endpoints: %s", svcfqdn, strings.Join(extra, ", "))
+	}
+
+	// Per pod records
+	for _, ep := range endpoints {
+		if ep.Hostname == "" {
+			continue
+		}
+		if ctx.Err() != nil {
+			res.Success = false
+			res.Err = ctx.Err()
+			return res
+		}
+		podfqdn := ep.Hostname + "." + svcfqdn
+		lookup := env.lookupHost(ctx, dnsServerURL, podfqdn)
+		ips, err := lookup.IPs(), lookup.Err()
+		switch {
+		case err != nil:
+			res.addDetail("%s: lookup failed: %v. Expected %s", podfqdn, err, ep.IP)
+			res.Success = false
+		case len(ips) != 1 || normalizeIP(ips[0]) != normalizeIP(ep.IP):
+			res.addDetail("%s: resolved to %v. Expected %s", podfqdn, ips, ep.IP)
+			res.Success = false
+		default:
+			res.addDetail("%s: %s", podfqdn, ep.IP)
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) headless service dns lookup for %s\n", svcfqdn)
+	} else {
+		env.Log.Debug("  (Failed) headless service dns lookup for %s\n", svcfqdn)
+	}
+	return res
+}
+
+// RunSearchPathResolutionCheck replays the search list expansion done by the SrcPod's
+// resolver when looking up name & times each query. Passes if name resolves. Queries
+// for names that don't exist (NXDOMAIN) are reported as they add to lookup latency
+func RunSearchPathResolutionCheck(ctx context.Context, env *Env, name string) Result {
+	var res Result
+	conf := env.Cfg.SrcPod.DNSConfig
+	if conf == nil {
+		res.Err = fmt.Errorf("unable to read /etc/resolv.conf of SrcPod")
+		return res
+	}
+	dnsServerURL := net.JoinHostPort(env.Cfg.dnsServer(), "53")
+	names := expandSearchList(name, conf.Search, conf.Ndots, conf.Resolver)
+	res.addDetail("%s resolver, ndots:%d, %d candidate names", conf.Resolver, conf.Ndots, len(names))
+	lookupCount, nxdomainCount := 0, 0
+	var total time.Duration
+	for _, candidate := range names {
+		lookupCount++
+		start := time.Now()
+		lookup := env.lookupHost(ctx, dnsServerURL, candidate)
+		ips, err := lookup.IPs(), lookup.Err()
+		elapsed := time.Since(start)
+		total += elapsed
+		if ctx.Err() != nil {
+			res.Err = ctx.Err()
+			return res
+		}
+		if err == nil && len(ips) > 0 {
+			res.addDetail("%s: %v in %v", candidate, ips, elapsed)
+			res.Success = true
+			break
+		}
+		nxdomain := errors.Is(err, netutils.ErrNXDomain)
+		if nxdomain {
+			nxdomainCount++
+			res.addDetail("%s: NXDOMAIN in %v", candidate, elapsed)
+		} else if err != nil {
+			res.addDetail("%s: %v in %v", candidate, err, elapsed)
+		} else {
+			res.addDetail("%s: no records in %v", candidate, elapsed)
+		}
+		// musl stops at the first response other than NXDOMAIN
+		if conf.Resolver == resolverMusl && !nxdomain {
+			break
+		}
+	}
+	res.addDetail("%d lookups, %d NXDOMAIN, total %v", lookupCount, nxdomainCount, total)
+	if nxdomainCount > 0 && !strings.HasSuffix(name, ".") {
+		res.addDetail("use %s. (trailing dot) or lower ndots to avoid %d wasted lookups", name, nxdomainCount)
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) %s resolved after %d NXDOMAIN responses in %v\n", name, nxdomainCount, total)
+	} else {
+		env.Log.Debug("  (Failed) %s did not resolve using the search list\n", name)
+	}
+	return res
+}
+
+// RunEDNSBufferSizeCheck queries the nameserver using decreasing EDNS0 UDP buffer sizes to
+// find the largest one that gets a response. Fails if queries advertising the default
+// buffer size used by k8snetlook go unanswered. The responses are small, so fragments of
+// large responses being dropped along the path isn't detected
+func RunEDNSBufferSizeCheck(ctx context.Context, env *Env, dnsServerIP string) Result {
+	var res Result
+	if dnsServerIP == "" {
+		res.Err = fmt.Errorf("kube-dns service not found")
+		return res
+	}
+	dnsServerURL := net.JoinHostPort(dnsServerIP, "53")
+	probe, err := netutils.ProbeEDNSBufferSize(ctx, dnsServerURL, env.Cfg.serviceFQDN("kubernetes", "default"))
+	env.recordFlow("UDP", probe.LocalAddr, dnsServerURL, true)
+	if err != nil {
+		env.Log.Debug("  (Failed) EDNS probe of %s, error: %v\n", dnsServerURL, err)
+		res.Err = err
+		return res
+	}
+	if !probe.Supported {
+		res.addDetail("%s: EDNS0 not supported, UDP responses are limited to %d bytes", dnsServerIP, probe.MaxWorkingSize)
+	} else {
+		res.addDetail("%s: EDNS0 supported, server buffer size %d", dnsServerIP, probe.ServerSize)
+	}
+	res.addDetail("largest working buffer size: %d, response size %d bytes", probe.MaxWorkingSize, probe.ResponseSize)
+	for _, size := range probe.Failed {
+		res.addDetail("buffer size %d: no response", size)
+	}
+	res.Success = probe.MaxWorkingSize >= env.Cfg.dnsOptions().UDPSize
+	if res.Success {
+		env.Log.Debug("  (Passed) EDNS buffer size %d works with %s\n", probe.MaxWorkingSize, dnsServerIP)
+	} else {
+		res.addDetail("queries advertising a buffer size larger than %d get no response", probe.MaxWorkingSize)
+		env.Log.Debug("  (Failed) EDNS buffer size %d fails with %s\n", env.Cfg.dnsOptions().UDPSize, dnsServerIP)
+	}
+	return res
+}
+
+// RunDNSReplicasCheck queries each ready kube-dns endpoint directly, bypassing the ClusterIP,
+// so that a single broken replica isn't hidden behind load balancing. Each replica is asked
+// for the kubernetes service, the PTR record of its ClusterIP & ExternalHost if set
+func RunDNSReplicasCheck(ctx context.Context, env *Env) Result {
+	var res Result
+	endpoints, notReady := splitEndpoints(env.getEndpointsFromService(ctx, "kube-system", "kube-dns"))
+	if len(endpoints) == 0 {
+		res.Err = fmt.Errorf("no ready endpoints found for kube-dns service")
+		return res
+	}
+	apiIP := env.Cfg.KubeAPIService.IP
+	apiFQDN := env.Cfg.serviceFQDN("kubernetes", "default")
+	if env.Cfg.ExternalHost == "" {
+		res.addDetail("external name lookup skipped. Set -externalhost to include it")
+	}
+	failedCount := 0
+	for _, ep := range endpoints {
+		port := int32(53)
+		if p, ok := ep.lookupPort("dns"); ok {
+			port = p.Port
+		}
+		dnsServerURL := net.JoinHostPort(ep.IP, strconv.Itoa(int(port)))
+		replica := ep.IP
+		if ep.NodeName != "" {
+			replica = fmt.Sprintf("%s (node %s)", ep.IP, ep.NodeName)
+		}
+		var problems []string
+		svc := env.queryDNS(ctx, dnsServerURL, apiFQDN, dns.TypeA)
+		if net.ParseIP(apiIP).To4() == nil {
+			svc = env.queryDNS(ctx, dnsServerURL, apiFQDN, dns.TypeAAAA)
+		}
+		res.addDetail("%s: %s", replica, formatDNSQuery(svc))
+		if svc.Err != nil {
+			problems = append(problems, svc.Type)
+		} else if !containsIP(svc.Answers, apiIP) {
+			res.addDetail("%s: expected %s", replica, apiIP)
+			problems = append(problems, svc.Type)
+		}
+		ptr := env.lookupAddr(ctx, dnsServerURL, apiIP)
+		res.addDetail("%s: %s", replica, formatDNSQuery(ptr))
+		if ptr.Err != nil {
+			problems = append(problems, ptr.Type)
+		} else if !containsName(ptr.Answers, apiFQDN) {
+			res.addDetail("%s: expected %s", replica, apiFQDN)
+			problems = append(problems, ptr.Type)
+		}
+		if env.Cfg.ExternalHost != "" {
+			external := env.lookupHost(ctx, dnsServerURL, env.Cfg.ExternalHost)
+			res.addDetail("%s: %s", replica, formatDNSQuery(external.A))
+			res.addDetail("%s: %s", replica, formatDNSQuery(external.AAAA))
+			if external.Err() != nil || len(external.IPs()) == 0 {
+				problems = append(problems, "external")
+			}
+		}
+		if ctx.Err() != nil {
+			res.Err = ctx.Err()
+			return res
+		}
+		if len(problems) > 0 {
+			failedCount++
+			res.addDetail("%s: failed (%s)", replica, strings.Join(problems, ", "))
+			env.Log.Debug("  (Failed) kube-dns replica %s: %v\n", ep.IP, problems)
+		} else {
+			env.Log.Debug("  (Passed) kube-dns replica %s\n", ep.IP)
+		}
+	}
+	for _, ep := range notReady {
+		res.addDetail("%s: skipped (%s)", ep.IP, ep.conditions())
+	}
+	res.addDetail("%d of %d replicas failed", failedCount, len(endpoints))
+	res.Success = failedCount == 0
+	return res
+}
+
+// RunNodeLocalDNSCheck looks up the kubernetes service using the NodeLocal DNSCache IPs &
+// the kube-dns ClusterIP. Fails if a nameserver the SrcPod is configured with doesn't
+// answer. Flags pods configured for one of them when only the other works
+func RunNodeLocalDNSCheck(ctx context.Context, env *Env) Result {
+	return runNodeLocalDNSCheck(ctx, env, "53")
+}
+
+// runNodeLocalDNSCheck runs the NodeLocal DNSCache check sending queries to dnsPort
+func runNodeLocalDNSCheck(ctx context.Context, env *Env, dnsPort string) Result {
+	var res Result
+	nodeLocal := env.Cfg.NodeLocalDNS
+	if nodeLocal.DaemonSet != "" {
+		res.addDetail("daemonset: kube-system/%s", nodeLocal.DaemonSet)
+	} else {
+		res.addDetail("daemonset: not found")
+	}
+	if !nodeLocal.Interface {
+		res.addDetail("%s interface not found on host. Assuming %s", nodeLocalDNSInterface, strings.Join(nodeLocal.IPs, ", "))
+	}
+	svcfqdn := env.Cfg.serviceFQDN("kubernetes", "default")
+	answers := func(ip string) bool {
+		lookup := env.lookupHost(ctx, net.JoinHostPort(ip, dnsPort), svcfqdn)
+		res.addDetail("%s: %s", ip, formatDNSQuery(lookup.A))
+		return lookup.Err() == nil && containsIP(lookup.IPs(), env.Cfg.KubeAPIService.IP)
+	}
+	cacheWorks := false
+	for _, ip := range nodeLocal.IPs {
+		if normalizeIP(ip) == normalizeIP(env.Cfg.KubeDNSService.IP) {
+			// In iptables mode the cache also listens on the kube-dns ClusterIP
+			continue
+		}
+		if answers(ip) {
+			cacheWorks = true
+		}
+	}
+	upstreamWorks := env.Cfg.KubeDNSService.IP != "" && answers(env.Cfg.KubeDNSService.IP)
+	if ctx.Err() != nil {
+		res.Err = ctx.Err()
+		return res
+	}
+
+	usesCache, usesUpstream := false, false
+	if conf := env.Cfg.SrcPod.DNSConfig; conf != nil {
+		res.addDetail("pod nameservers: %s", strings.Join(conf.Nameservers, ", "))
+		usesCache = containsAnyIP(conf.Nameservers, nodeLocal.IPs)
+		usesUpstream = containsIP(conf.Nameservers, env.Cfg.KubeDNSService.IP)
+	} else {
+		res.addDetail("pod nameservers unknown. Expecting both the cache & kube-dns to answer")
+		usesCache, usesUpstream = true, true
+	}
+	switch {
+	case usesCache && !cacheWorks && upstreamWorks:
+		res.addDetail("pod is configured for the local cache but only kube-dns answers. node-local-dns may not be running on this node")
+	case usesUpstream && !upstreamWorks && cacheWorks:
+		res.addDetail("pod is configured for kube-dns but only the local cache answers. Check kube-dns or the cache's upstream")
+	case !usesCache && !usesUpstream:
+		res.addDetail("pod uses neither the local cache nor kube-dns")
+	}
+	res.Success = (usesCache || usesUpstream) && (!usesCache || cacheWorks) && (!usesUpstream || upstreamWorks)
+	if res.Success {
+		env.Log.Debug("  (Passed) NodeLocal DNSCache check\n")
+	} else {
+		env.Log.Debug("  (Failed) NodeLocal DNSCache check. cache: %v, kube-dns: %v\n", cacheWorks, upstreamWorks)
+	}
+	return res
+}
+
+// RunServiceDNSRecordsCheck validates the SRV records of the named ports of the service &
+// the PTR records of its ClusterIP & ready endpoints. SRV records of a ClusterIP service
+// point to the service port. Headless services have a record per endpoint instead
+func RunServiceDNSRecordsCheck(ctx context.Context, env *Env, dnsServerIP string, svc Service) Result {
+	var res Result
+	if dnsServerIP == "" {
+		res.Err = fmt.Errorf("kube-dns service not found")
+		return res
+	}
+	return runServiceDNSRecordsCheck(ctx, env, net.JoinHostPort(dnsServerIP, "53"), svc)
+}
+
+// runServiceDNSRecordsCheck is RunServiceDNSRecordsCheck using the nameserver at dnsServerURL
+func runServiceDNSRecordsCheck(ctx context.Context, env *Env, dnsServerURL string, svc Service) Result {
+	var res Result
+	svcfqdn := env.Cfg.serviceFQDN(svc.Name, svc.Namespace)
+	headless := svc.ClusterIP == "None"
+	res.Success = true
+
+	for _, port := range svc.Ports {
+		if port.Name == "" {
+			// SRV records are only published for named ports
+			continue
+		}
+		// Expected target:port of each record
+		expected := map[string]bool{}
+		if headless {
+			for _, ep := range svc.SvcEndpoints {
+				for _, epPort := range ep.Ports {
+					if epPort.Name == port.Name && epPort.Protocol == port.Protocol {
+						expected[net.JoinHostPort(endpointDNSName(ep, svcfqdn), strconv.Itoa(int(epPort.Port)))] = true
+					}
+				}
+			}
+		} else {
+			expected[net.JoinHostPort(svcfqdn, strconv.Itoa(int(port.Port)))] = true
+		}
+		query := env.lookupSRV(ctx, dnsServerURL, port.Name, strings.ToLower(port.Protocol), svcfqdn)
+		res.addDetail("%s: %s", port, formatDNSQuery(query))
+		if query.Err != nil && len(expected) > 0 {
+			res.Success = false
+			continue
+		}
+		actual := map[string]bool{}
+		for _, answer := range query.Answers {
+			actual[strings.ToLower(answer)] = true
+		}
+		missing, extra := diffIPSets(lowerKeys(expected), actual)
+		if len(missing) > 0 {
+			res.addDetail("%s: missing SRV records: %s", port, strings.Join(missing, ", "))
+			res.Success = false
+		}
+		if len(extra) > 0 {
+			res.addDetail("%s: unexpected SRV records: %s", port, strings.Join(extra, ", "))
+			res.Success = false
+		}
+		if ctx.Err() != nil {
+			res.Success = false
+			res.Err = ctx.Err()
+			return res
+		}
+	}
+
+	// PTR records map the ClusterIP to the service & endpoint IPs to names under the service
+	ptrs := map[string]string{}
+	for _, clusterIP := range svc.clusterIPs() {
+		ptrs[clusterIP] = svcfqdn
+	}
+	for _, ep := range svc.SvcEndpoints {
+		ptrs[ep.IP] = endpointDNSName(ep, svcfqdn)
+	}
+	ips := make([]string, 0, len(ptrs))
+	for ip := range ptrs {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	domain := env.Cfg.ClusterDomain
+	if domain == "" {
+		domain = defaultClusterDomain
+	}
+	clusterSuffix := ".svc." + strings.TrimSuffix(domain, ".") + "."
+	for _, ip := range ips {
+		query := env.lookupAddr(ctx, dnsServerURL, ip)
+		res.addDetail("%s: %s", ip, formatDNSQuery(query))
+		switch {
+		case query.Err != nil:
+			res.Success = false
+		case containsName(query.Answers, ptrs[ip]):
+		case len(query.Answers) > 0 && strings.HasSuffix(strings.ToLower(query.Answers[0]), clusterSuffix):
+			// Pods backing several services get the record of one of them
+			res.addDetail("%s: record of another service. Expected %s", ip, ptrs[ip])
+		default:
+			res.addDetail("%s: expected %s", ip, ptrs[ip])
+			res.Success = false
+		}
+		if ctx.Err() != nil {
+			res.Success = false
+			res.Err = ctx.Err()
+			return res
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) SRV & PTR records of %s\n", svcfqdn)
+	} else {
+		env.Log.Debug("  (Failed) SRV & PTR records of %s\n", svcfqdn)
+	}
+	return res
+}
+
+// endpointDNSName returns the name of the endpoint under the service. The hostname of the
+// endpoint is used if set. Otherwise the name is derived from the IP. Eg: 10-244-0-5
+func endpointDNSName(ep Endpoint, svcfqdn string) string {
+	label := ep.Hostname
+	if label == "" {
+		label = strings.NewReplacer(".", "-", ":", "-").Replace(ep.IP)
+	}
+	return label + "." + svcfqdn
+}
+
+// lowerKeys returns a copy of set with lower case keys
+func lowerKeys(set map[string]bool) map[string]bool {
+	ret := make(map[string]bool, len(set))
+	for k, v := range set {
+		ret[strings.ToLower(k)] = v
+	}
+	return ret
+}
+
+// containsAnyIP checks if ips contains any of the IPs in candidates
+func containsAnyIP(ips, candidates []string) bool {
+	for _, ip := range candidates {
+		if containsIP(ips, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsIP checks if ips contains ip
+func containsIP(ips []string, ip string) bool {
+	for _, v := range ips {
+		if normalizeIP(v) == normalizeIP(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsName checks if names contains name. Names are compared ignoring case
+func containsName(names []string, name string) bool {
+	for _, v := range names {
+		if strings.EqualFold(dns.Fqdn(v), dns.Fqdn(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffIPSets returns the sorted IPs that are in expected but not in actual & vice versa
+func diffIPSets(expected, actual map[string]bool) ([]string, []string) {
+	var missing, extra []string
+	for ip := range expected {
+		if !actual[ip] {
+			missing = append(missing, ip)
+		}
+	}
+	for ip := range actual {
+		if !expected[ip] {
+			extra = append(extra, ip)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra
+}
+
+// normalizeIP returns the canonical representation of ip so that IPv6 addresses compare equal
+func normalizeIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
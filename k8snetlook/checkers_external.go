@@ -0,0 +1,260 @@
+package k8snetlook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/sarun87/k8snetlook/netutils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Outcome of probing a single ip:port
+type probeOutcome int
+
+const (
+	probePassed probeOutcome = iota
+	probeFailed
+	probeInconclusive
+)
+
+func init() {
+	Register(&checker{
+		name:          "dstsvc-nodeport",
+		description:   "DstSvc NodePort connectivity check",
+		scope:         ScopeHost | ScopePod,
+		prerequisites: []Prerequisite{PrereqDstSvcNodePort},
+		run: func(ctx context.Context, env *Env) Result {
+			res := RunNodePortConnectivityCheck(ctx, env, env.Cfg.DstSvc)
+			addNotReadyDetails(&res, env.Cfg.DstSvc)
+			return res
+		},
+	})
+	Register(&checker{
+		name:          "dstsvc-loadbalancer",
+		description:   "DstSvc LoadBalancer ingress connectivity check",
+		scope:         ScopeHost | ScopePod,
+		prerequisites: []Prerequisite{PrereqDstSvcLoadBalancer},
+		run: func(ctx context.Context, env *Env) Result {
+			return RunLoadBalancerConnectivityCheck(ctx, env, env.Cfg.DstSvc)
+		},
+	})
+	Register(&checker{
+		name:          "dstsvc-externalname",
+		description:   "DstSvc ExternalName resolution check",
+		scope:         ScopeHost | ScopePod,
+		prerequisites: []Prerequisite{PrereqDstSvcExternalName},
+		run: func(ctx context.Context, env *Env) Result {
+			return RunExternalNameResolutionCheck(ctx, env, env.Cfg.DstSvc)
+		},
+	})
+}
+
+// RunNodePortConnectivityCheck connects to every NodePort of the service on the local
+// node & on all of the other nodes. With externalTrafficPolicy Local, remote nodes
+// without a ready endpoint are expected to drop traffic & aren't counted as failures
+func RunNodePortConnectivityCheck(ctx context.Context, env *Env, svc Service) Result {
+	var res Result
+	if !svc.hasNodePorts() {
+		res.Err = fmt.Errorf("service %s/%s has no NodePorts allocated", svc.Namespace, svc.Name)
+		return res
+	}
+	if len(env.Cfg.Nodes) == 0 {
+		res.Err = fmt.Errorf("unable to fetch nodes to probe NodePorts on")
+		return res
+	}
+	nodesWithEndpoints := map[string]bool{}
+	for _, ep := range svc.SvcEndpoints {
+		nodesWithEndpoints[ep.NodeName] = true
+	}
+	localPolicy := svc.ExternalTrafficPolicy == string(corev1.ServiceExternalTrafficPolicyTypeLocal)
+	// Probe the local node first
+	nodes := make([]Node, 0, len(env.Cfg.Nodes))
+	for _, node := range env.Cfg.Nodes {
+		if node.Name == env.Cfg.LocalNodeName {
+			nodes = append([]Node{node}, nodes...)
+		} else {
+			nodes = append(nodes, node)
+		}
+	}
+	res.Success = true
+	for _, port := range svc.Ports {
+		if port.NodePort == 0 {
+			continue
+		}
+		for _, node := range nodes {
+			if node.IP == "" {
+				res.addDetail("%s: node %s has no IP address", port, node.Name)
+				continue
+			}
+			nodeDesc := node.Name
+			if node.Name == env.Cfg.LocalNodeName {
+				nodeDesc += " (local)"
+			}
+			outcome, detail := probePort(ctx, env, node.IP, port.NodePort, port)
+			// Traffic originating on the local node is always load balanced to all endpoints
+			expectDrop := localPolicy && node.Name != env.Cfg.LocalNodeName && !nodesWithEndpoints[node.Name]
+			switch {
+			case expectDrop && outcome != probePassed:
+				res.addDetail("%s: node %s: %s. Expected, no local endpoints with externalTrafficPolicy Local", port, nodeDesc, detail)
+			case outcome == probeFailed:
+				res.addDetail("%s: node %s: %s", port, nodeDesc, detail)
+				res.Success = false
+			default:
+				res.addDetail("%s: node %s: %s", port, nodeDesc, detail)
+			}
+			if ctx.Err() != nil {
+				res.Success = false
+				res.Err = ctx.Err()
+				return res
+			}
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) NodePort connectivity check")
+	} else {
+		env.Log.Debug("  (Failed) NodePort connectivity check")
+	}
+	return res
+}
+
+// RunLoadBalancerConnectivityCheck connects to every port of the service on each of
+// the load balancer ingress IPs/hostnames. Hostnames are resolved using cluster DNS.
+// Fails if no ingress has been assigned
+func RunLoadBalancerConnectivityCheck(ctx context.Context, env *Env, svc Service) Result {
+	var dnsServerURL string
+	if dnsServerIP := env.Cfg.dnsServer(); dnsServerIP != "" {
+		dnsServerURL = net.JoinHostPort(dnsServerIP, "53")
+	}
+	return runLoadBalancerConnectivityCheck(ctx, env, dnsServerURL, svc)
+}
+
+// runLoadBalancerConnectivityCheck is RunLoadBalancerConnectivityCheck resolving ingress
+// hostnames using the nameserver at dnsServerURL
+func runLoadBalancerConnectivityCheck(ctx context.Context, env *Env, dnsServerURL string, svc Service) Result {
+	var res Result
+	if len(svc.LoadBalancerIngress) == 0 {
+		res.Err = fmt.Errorf("no load balancer ingress assigned to service %s/%s. Load balancer provisioning may be pending", svc.Namespace, svc.Name)
+		return res
+	}
+	if svc.ExternalTrafficPolicy == string(corev1.ServiceExternalTrafficPolicyTypeLocal) {
+		res.addDetail("externalTrafficPolicy Local: load balancer sends traffic only to nodes with ready endpoints")
+	}
+	res.Success = true
+	for _, ingress := range svc.LoadBalancerIngress {
+		ips := []string{ingress}
+		if net.ParseIP(ingress) == nil {
+			if dnsServerURL == "" {
+				res.addDetail("%s: unable to resolve hostname: kube-dns service not found", ingress)
+				res.Success = false
+				continue
+			}
+			lookup := env.lookupHost(ctx, dnsServerURL, ingress)
+			if ips = lookup.IPs(); lookup.Err() != nil || len(ips) == 0 {
+				res.addDetail("%s: unable to resolve hostname. Error: %v", ingress, lookup.Err())
+				res.Success = false
+				continue
+			}
+		}
+		for _, ip := range ips {
+			for _, port := range svc.Ports {
+				outcome, detail := probePort(ctx, env, ip, port.Port, port)
+				if outcome == probeFailed {
+					res.Success = false
+				}
+				if ip != ingress {
+					res.addDetail("%s: %s (%s): %s", port, ingress, ip, detail)
+				} else {
+					res.addDetail("%s: %s: %s", port, ingress, detail)
+				}
+				if ctx.Err() != nil {
+					res.Success = false
+					res.Err = ctx.Err()
+					return res
+				}
+			}
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) LoadBalancer connectivity check")
+	} else {
+		env.Log.Debug("  (Failed) LoadBalancer connectivity check")
+	}
+	return res
+}
+
+// RunExternalNameResolutionCheck looks up the service name using cluster DNS. Passes if
+// the lookup follows the CNAME to the external name & returns IPs
+func RunExternalNameResolutionCheck(ctx context.Context, env *Env, svc Service) Result {
+	dnsServerIP := env.Cfg.dnsServer()
+	if dnsServerIP == "" {
+		var res Result
+		res.Err = fmt.Errorf("kube-dns service not found")
+		return res
+	}
+	return runExternalNameResolutionCheck(ctx, env, net.JoinHostPort(dnsServerIP, "53"), svc)
+}
+
+// runExternalNameResolutionCheck is RunExternalNameResolutionCheck using the nameserver
+// at dnsServerURL
+func runExternalNameResolutionCheck(ctx context.Context, env *Env, dnsServerURL string, svc Service) Result {
+	var res Result
+	svcfqdn := env.Cfg.serviceFQDN(svc.Name, svc.Namespace)
+	lookup := env.lookupHost(ctx, dnsServerURL, svcfqdn)
+	ips, err := lookup.IPs(), lookup.Err()
+	if err == nil && len(ips) > 0 {
+		env.Log.Debug("  (Passed) %s -> %s resolved to %v\n", svcfqdn, svc.ExternalName, ips)
+		res.addDetail("%s -> %s: %v", svcfqdn, svc.ExternalName, ips)
+		res.Success = true
+		return res
+	}
+	res.addDetail("%s: lookup returned no IPs. Error: %v", svcfqdn, err)
+	// Look up the external name directly to find out if the target itself doesn't resolve
+	lookup = env.lookupHost(ctx, dnsServerURL, svc.ExternalName)
+	if ips, err := lookup.IPs(), lookup.Err(); err != nil || len(ips) == 0 {
+		res.addDetail("%s: lookup returned no IPs. Error: %v. External name may not exist", svc.ExternalName, err)
+	} else {
+		res.addDetail("%s: %v. Cluster DNS isn't returning the CNAME for the service", svc.ExternalName, ips)
+	}
+	env.Log.Debug("  (Failed) ExternalName resolution check for %s\n", svcfqdn)
+	return res
+}
+
+// probePort probes ip:dstPort using the protocol of the service port. Returns the
+// outcome & its description
+func probePort(ctx context.Context, env *Env, ip string, dstPort int32, port Port) (probeOutcome, string) {
+	addr := net.JoinHostPort(ip, strconv.Itoa(int(dstPort)))
+	switch port.Protocol {
+	case "TCP":
+		conn, err := netutils.TCPConnect(ctx, ip, dstPort)
+		if err != nil {
+			env.Log.Debug("    (Failed) Error connecting to %s. Error: %v\n", addr, err)
+			return probeFailed, fmt.Sprintf("%s error: %v", addr, err)
+		}
+		env.Log.Debug("    %s: %s in %v\n", addr, conn.State, conn.RTT)
+		env.recordFlow(port.Protocol, conn.LocalAddr, addr, true)
+		if conn.State == netutils.TCPConnected {
+			return probePassed, fmt.Sprintf("%s connected in %v", addr, conn.RTT)
+		}
+		return probeFailed, fmt.Sprintf("%s %s after %v", addr, conn.State, conn.RTT)
+	case "UDP":
+		// Payload is picked based on the service port since the NodePort is arbitrary
+		payload, _ := udpProbePayload(env.Cfg, port)
+		probe, err := netutils.SendRecvUDPProbe(ctx, ip, dstPort, payload)
+		if err != nil {
+			env.Log.Debug("    (Failed) Error probing %s. Error: %v\n", addr, err)
+			return probeFailed, fmt.Sprintf("%s error: %v", addr, err)
+		}
+		env.Log.Debug("    %s: %s in %v\n", addr, probe.State, probe.RTT)
+		env.recordFlow(port.Protocol, probe.LocalAddr, addr, probe.State == netutils.UDPAnswered)
+		switch probe.State {
+		case netutils.UDPAnswered:
+			return probePassed, fmt.Sprintf("%s answered in %v", addr, probe.RTT)
+		case netutils.UDPUnreachable:
+			return probeFailed, fmt.Sprintf("%s ICMP unreachable in %v", addr, probe.RTT)
+		}
+		return probeInconclusive, fmt.Sprintf("%s no reply after %v", addr, probe.RTT)
+	}
+	return probeInconclusive, fmt.Sprintf("%s protocol %s not supported", addr, port.Protocol)
+}
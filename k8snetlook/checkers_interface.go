@@ -0,0 +1,156 @@
+package k8snetlook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sarun87/k8snetlook/netutils"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	// podInterface is the interface of the pod connected to the host by the CNI plugin
+	podInterface = "eth0"
+	// errorSampleInterval is the time between the two reads of the error counters
+	errorSampleInterval = time.Second
+)
+
+func init() {
+	Register(&checker{
+		name:        "pod-interface",
+		description: "SrcPod interface & host veth peer check",
+		scope:       ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return RunPodInterfaceCheck(ctx, env)
+		},
+	})
+}
+
+// RunPodInterfaceCheck lists the interfaces of the SrcPod & looks up the host end of the
+// veth pair of eth0 using the peer index. The state, carrier, MTU & error counters of both
+// ends are reported along with the bridge or OVS port the host end is attached to, or the
+// host route to the SrcPod if it isn't enslaved. The counters are read twice as errors
+// counted since the link was created may be long gone. Fails if either end is down, the
+// MTUs differ or errors are counted between the two reads
+func RunPodInterfaceCheck(ctx context.Context, env *Env) Result {
+	var res Result
+	links, err := netutils.GetLinks(netns.None())
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	var podLink *netutils.LinkInfo
+	for i, link := range links {
+		if link.Type == "loopback" || link.Name == "lo" {
+			continue
+		}
+		res.addDetail("pod: %s", link)
+		if link.Name == podInterface {
+			podLink = &links[i]
+		}
+	}
+	if podLink == nil {
+		res.Err = fmt.Errorf("interface %s not found in SrcPod netns", podInterface)
+		return res
+	}
+	res.Success = checkLinkUp(&res, "pod", *podLink)
+	if podLink.Type != "veth" {
+		// ipvlan & macvlan links have a parent in the host netns instead of a peer
+		parent, err := netutils.GetLinkByIndex(env.HostNsHandle, podLink.ParentIndex)
+		if err != nil {
+			res.addDetail("pod: %s is a %s link. Parent not found in host netns: %v", podLink.Name, podLink.Type, err)
+		} else {
+			res.addDetail("pod: %s is a %s link of host %s", podLink.Name, podLink.Type, parent)
+		}
+		return res
+	}
+
+	peer, err := netutils.GetLinkByIndex(env.HostNsHandle, podLink.ParentIndex)
+	if err != nil || peer.Type != "veth" || peer.ParentIndex != podLink.Index {
+		res.addDetail("host: veth peer of %s (index %d) not found: %v", podLink.Name, podLink.ParentIndex, err)
+		res.Success = false
+		return res
+	}
+	res.addDetail("host: %s", peer)
+	if !checkLinkUp(&res, "host", peer) {
+		res.Success = false
+	}
+	if peer.MTU != podLink.MTU {
+		res.addDetail("MTU mismatch: pod %s %d, host %s %d", podLink.Name, podLink.MTU, peer.Name, peer.MTU)
+		res.Success = false
+	}
+	ends := []struct {
+		from     string
+		nsHandle netns.NsHandle
+		link     netutils.LinkInfo
+	}{{"pod", netns.None(), *podLink}, {"host", env.HostNsHandle, peer}}
+	for _, end := range ends {
+		res.addDetail("%s: %s: %s", end.from, end.link.Name, end.link.Counters())
+	}
+	select {
+	case <-ctx.Done():
+		res.Success = false
+		res.Err = ctx.Err()
+		return res
+	case <-time.After(errorSampleInterval):
+	}
+	for _, end := range ends {
+		link, err := netutils.GetLinkByIndex(end.nsHandle, end.link.Index)
+		if err != nil {
+			res.addDetail("%s: unable to read the counters of %s again: %v", end.from, end.link.Name, err)
+			continue
+		}
+		if rx, tx := newErrors(end.link, link); rx > 0 || tx > 0 {
+			res.addDetail("%s: %s: %d rx errors & %d tx errors in %v", end.from, link.Name, rx, tx, errorSampleInterval)
+			res.Success = false
+		}
+	}
+
+	switch {
+	case peer.Master != "":
+		res.addDetail("host: %s is a port of %s %s", peer.Name, peer.MasterType, peer.Master)
+	case env.Cfg.SrcPod.IP == "":
+		res.addDetail("host: %s isn't enslaved. SrcPod IP unknown, unable to check routing", peer.Name)
+	default:
+		route, err := netutils.GetRoute(env.HostNsHandle, env.Cfg.SrcPod.IP)
+		switch {
+		case err != nil:
+			res.addDetail("host: %s isn't enslaved & SrcPod %s has no route: %v", peer.Name, env.Cfg.SrcPod.IP, err)
+			res.Success = false
+		case route.LinkIndex != peer.Index:
+			// eBPF based CNI plugins such as Cilium route pods via their own device
+			res.addDetail("host: %s isn't enslaved & SrcPod %s is routed via %s", peer.Name, env.Cfg.SrcPod.IP, route.Interface)
+		default:
+			res.addDetail("host: routed. %s", route)
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) %s & host veth peer %s\n", podLink.Name, peer.Name)
+	} else {
+		env.Log.Debug("  (Failed) %s & host veth peer %s\n", podLink.Name, peer.Name)
+	}
+	return res
+}
+
+// newErrors returns the rx & tx errors counted between the before & after reads of a link.
+// Counters that went backwards, eg: the link was recreated, count as no new errors
+func newErrors(before, after netutils.LinkInfo) (uint64, uint64) {
+	var rx, tx uint64
+	if after.RxErrors > before.RxErrors {
+		rx = after.RxErrors - before.RxErrors
+	}
+	if after.TxErrors > before.TxErrors {
+		tx = after.TxErrors - before.TxErrors
+	}
+	return rx, tx
+}
+
+// checkLinkUp adds a detail to res if link isn't up or has no carrier. Returns false if so
+func checkLinkUp(res *Result, from string, link netutils.LinkInfo) bool {
+	if link.Up && link.Carrier {
+		return true
+	}
+	res.addDetail("%s: %s is down. Admin up: %v, carrier: %v, state: %s", from, link.Name, link.Up, link.Carrier, link.OperState)
+	return false
+}
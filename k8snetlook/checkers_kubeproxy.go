@@ -0,0 +1,252 @@
+package k8snetlook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sarun87/k8snetlook/netutils"
+)
+
+func init() {
+	Register(&checker{
+		name:          "dstsvc-iptables",
+		description:   "DstSvc kube-proxy iptables rules check",
+		scope:         ScopeHost,
+		prerequisites: []Prerequisite{PrereqDstSvcClusterIP},
+		run: func(ctx context.Context, env *Env) Result {
+			return RunKubeProxyIPTablesCheck(ctx, env, env.Cfg.DstSvc)
+		},
+	})
+	Register(&checker{
+		name:        "ipvs-services",
+		description: "kube-proxy IPVS virtual services check for kube-apiserver & DstSvc",
+		scope:       ScopeHost,
+		run: func(ctx context.Context, env *Env) Result {
+			return RunKubeProxyIPVSCheck(ctx, env)
+		},
+	})
+}
+
+// RunKubeProxyIPTablesCheck reads the nat table of the host & checks that KUBE-SERVICES
+// has a rule for each port of the service ClusterIP & that its KUBE-SVC-* chain has a
+// KUBE-SEP-* chain per ready endpoint. Endpoints missing from the rules & stale rules
+// for addresses that aren't ready endpoints are reported. The filter table is read for
+// the REJECT rules of ports without endpoints. Fails if a port with ready endpoints is
+// rejected
+func RunKubeProxyIPTablesCheck(ctx context.Context, env *Env, svc Service) Result {
+	var res Result
+	if svc.ClusterIP == "None" {
+		res.Err = fmt.Errorf("service %s/%s is headless. kube-proxy doesn't program rules for it", svc.Namespace, svc.Name)
+		return res
+	}
+	res.Success = true
+	for _, clusterIP := range svc.clusterIPs() {
+		if !checkKubeProxyIPTablesRules(ctx, &res, svc, clusterIP) {
+			res.Success = false
+		}
+		if res.Err != nil || res.Inconclusive {
+			res.Success = false
+			return res
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) kube-proxy iptables rules match endpoints of %s/%s\n", svc.Namespace, svc.Name)
+	} else {
+		env.Log.Debug("  (Failed) kube-proxy iptables rules don't match endpoints of %s/%s\n", svc.Namespace, svc.Name)
+	}
+	return res
+}
+
+// checkKubeProxyIPTablesRules adds the state of the kube-proxy rules of each port of
+// clusterIP to res. The tables of the IP family of clusterIP are read. Sets res.Err if
+// the tables can't be read & res.Inconclusive if iptables-save isn't installed or if
+// kube-proxy doesn't use iptables. Returns false if the rules don't match the ready
+// endpoints of svc
+func checkKubeProxyIPTablesRules(ctx context.Context, res *Result, svc Service, clusterIP string) bool {
+	ipv6 := net.ParseIP(clusterIP).To4() == nil
+	dump, err := netutils.IPTablesSave(ctx, "nat", ipv6)
+	if errors.Is(err, exec.ErrNotFound) {
+		// Minimal images don't ship iptables
+		res.addDetail("%v. Install iptables in the image to check kube-proxy rules", err)
+		res.Inconclusive = true
+		return false
+	}
+	if err != nil {
+		res.Err = err
+		return false
+	}
+	chains, err := netutils.ParseIPTablesSave(dump, "nat")
+	if err != nil {
+		res.Err = err
+		return false
+	}
+	var filterChains map[string][]netutils.IPTablesRule
+	if dump, err = netutils.IPTablesSave(ctx, "filter", ipv6); err == nil {
+		filterChains, err = netutils.ParseIPTablesSave(dump, "filter")
+	}
+	if err != nil {
+		res.addDetail("unable to read filter table, REJECT rules of newer kube-proxy versions not checked: %v", err)
+	}
+	endpoints := endpointsOfFamily(svc.SvcEndpoints, clusterIP)
+	ok := true
+	for _, port := range svc.Ports {
+		rules, err := findKubeProxyServiceRules(chains, clusterIP, port)
+		if err != nil {
+			// Likely running in IPVS or nftables mode
+			res.addDetail("%s", err)
+			res.Inconclusive = true
+			return false
+		}
+		expected := endpointAddrs(endpoints, port)
+		addr := net.JoinHostPort(clusterIP, strconv.Itoa(int(port.Port)))
+		if kubeProxyRejectsService(filterChains, clusterIP, port) {
+			rules.Rejected = true
+		}
+		if rules.Rejected && len(expected) > 0 {
+			res.addDetail("%s: %s: rejected as having no endpoints, but service has %d ready endpoints", port, addr, len(expected))
+			ok = false
+		}
+		if rules.SvcChain == "" {
+			if len(expected) == 0 {
+				res.addDetail("%s: %s: no %s chain, service has no ready endpoints. Rejected: %v", port, addr, kubeSvcChainPrefix+"*", rules.Rejected)
+			} else {
+				res.addDetail("%s: %s: no %s rule found", port, addr, kubeServicesChain)
+				ok = false
+			}
+			continue
+		}
+		actual := map[string]bool{}
+		for _, ep := range rules.Endpoints {
+			actual[ep] = true
+		}
+		missing, stale := diffIPSets(expected, actual)
+		res.addDetail("%s: %s -> %s: %d endpoints in rules, %d ready endpoints", port, addr, rules.SvcChain, len(actual), len(expected))
+		if len(missing) > 0 {
+			res.addDetail("%s: endpoints missing from rules: %s", port, strings.Join(missing, ", "))
+			ok = false
+		}
+		if len(stale) > 0 {
+			res.addDetail("%s: stale endpoints in rules: %s", port, strings.Join(stale, ", "))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// kubeIPVSInterface is the dummy interface kube-proxy binds ClusterIPs to in IPVS mode
+const kubeIPVSInterface = "kube-ipvs0"
+
+// RunKubeProxyIPVSCheck reads the IPVS virtual services of the host for the kube-apiserver
+// service & DstSvc if specified. The real servers of each port are compared with the ready
+// endpoints. Missing, stale & weight 0 real servers are reported. The ClusterIPs must also
+// be bound to kube-ipvs0. Inconclusive if kube-proxy isn't running in IPVS mode
+func RunKubeProxyIPVSCheck(ctx context.Context, env *Env) Result {
+	var res Result
+	ipvsServices, err := netutils.GetIPVSServices()
+	if err != nil {
+		res.addDetail("%v", err)
+		res.Inconclusive = true
+		return res
+	}
+	boundIPs, err := netutils.GetLinkIPs(kubeIPVSInterface)
+	if len(ipvsServices) == 0 || err != nil {
+		res.addDetail("%d IPVS virtual services, %s: %v. kube-proxy may not be running in IPVS mode", len(ipvsServices), kubeIPVSInterface, err)
+		res.Inconclusive = true
+		return res
+	}
+	kubeAPI := Service{Name: "kubernetes", Namespace: "default", ClusterIP: env.Cfg.KubeAPIService.IP, Ports: env.Cfg.KubeAPIService.Ports}
+	kubeAPI.SvcEndpoints, _ = splitEndpoints(env.getEndpointsFromService(ctx, "default", "kubernetes"))
+	services := []Service{kubeAPI}
+	if env.Cfg.DstSvc.ClusterIP != "" && env.Cfg.DstSvc.ClusterIP != "None" {
+		services = append(services, env.Cfg.DstSvc)
+	}
+	res.Success = true
+	for _, svc := range services {
+		if !checkIPVSService(env, &res, svc, ipvsServices, boundIPs) {
+			res.Success = false
+		}
+		if ctx.Err() != nil {
+			res.Success = false
+			res.Err = ctx.Err()
+			return res
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) IPVS virtual services match service endpoints\n")
+	} else {
+		env.Log.Debug("  (Failed) IPVS virtual services don't match service endpoints\n")
+	}
+	return res
+}
+
+// checkIPVSService adds the state of the IPVS virtual services of each port of every
+// ClusterIP of svc to res. Returns false if the virtual services don't match the ready
+// endpoints of svc
+func checkIPVSService(env *Env, res *Result, svc Service, ipvsServices []netutils.IPVSService, boundIPs []string) bool {
+	ok := true
+	name := svc.Namespace + "/" + svc.Name
+	for _, clusterIP := range svc.clusterIPs() {
+		endpoints := endpointsOfFamily(svc.SvcEndpoints, clusterIP)
+		if !containsIP(boundIPs, clusterIP) {
+			res.addDetail("%s: ClusterIP %s not bound to %s", name, clusterIP, kubeIPVSInterface)
+			ok = false
+		}
+		for _, port := range svc.Ports {
+			addr := net.JoinHostPort(clusterIP, strconv.Itoa(int(port.Port)))
+			ipvsSvc, found := findIPVSService(ipvsServices, clusterIP, port)
+			if !found {
+				res.addDetail("%s: %s: %s: no IPVS virtual service", name, port, addr)
+				ok = false
+				continue
+			}
+			dests, err := netutils.GetIPVSDestinations(ipvsSvc)
+			if err != nil {
+				res.addDetail("%s: %s: %s: unable to list real servers: %v", name, port, addr, err)
+				ok = false
+				continue
+			}
+			expected := endpointAddrs(endpoints, port)
+			actual := map[string]bool{}
+			for _, dest := range dests {
+				real := net.JoinHostPort(dest.Address, strconv.Itoa(int(dest.Port)))
+				actual[real] = true
+				if dest.Weight != 0 {
+					continue
+				}
+				// kube-proxy sets the weight of terminating endpoints to 0. Ready endpoints
+				// with weight 0 don't receive new connections
+				res.addDetail("%s: %s: real server %s has weight 0. Active connections: %d", name, port, real, dest.ActiveConns)
+				if expected[real] {
+					ok = false
+				}
+			}
+			res.addDetail("%s: %s: %s (%s): %d real servers, %d ready endpoints", name, port, addr, ipvsSvc.Scheduler, len(actual), len(expected))
+			missing, stale := diffIPSets(expected, actual)
+			if len(missing) > 0 {
+				res.addDetail("%s: %s: endpoints missing from real servers: %s", name, port, strings.Join(missing, ", "))
+				ok = false
+			}
+			if len(stale) > 0 {
+				res.addDetail("%s: %s: real servers that aren't ready endpoints: %s", name, port, strings.Join(stale, ", "))
+				ok = false
+			}
+			env.Log.Debug("  IPVS %s: %d real servers, %d ready endpoints\n", addr, len(actual), len(expected))
+		}
+	}
+	return ok
+}
+
+// findIPVSService returns the virtual service of ip & port
+func findIPVSService(services []netutils.IPVSService, ip string, port Port) (netutils.IPVSService, bool) {
+	for _, svc := range services {
+		if svc.FWMark == 0 && normalizeIP(svc.Address) == normalizeIP(ip) && int32(svc.Port) == port.Port && svc.Protocol == port.Protocol {
+			return svc, true
+		}
+	}
+	return netutils.IPVSService{}, false
+}
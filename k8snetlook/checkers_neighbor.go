@@ -0,0 +1,112 @@
+package k8snetlook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sarun87/k8snetlook/netutils"
+	"github.com/vishvananda/netns"
+)
+
+func init() {
+	Register(&checker{
+		name:        "neighbors",
+		description: "Neighbor (ARP/NDP) resolution check for gateway & next hops",
+		scope:       ScopeHost | ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return RunNeighborCheck(ctx, env)
+		},
+	})
+}
+
+// nextHop is a neighbor packets to one or more destinations are sent to
+type nextHop struct {
+	ip           string
+	iface        string
+	destinations []string // Names of the destinations routed via the next hop
+}
+
+// nextHops returns the next hops of the destinations tested by the checks of the
+// environment. Directly connected destinations are their own next hop
+func (e *Env) nextHops(ctx context.Context) ([]*nextHop, error) {
+	var ret []*nextHop
+	seen := map[string]*nextHop{}
+	for _, dst := range e.destinations(ctx) {
+		route, err := netutils.GetRoute(netns.None(), dst.ip)
+		if err != nil || route.Type != "unicast" || route.Interface == "" {
+			continue
+		}
+		ip := route.Gw
+		if ip == "" {
+			ip = dst.ip
+		}
+		key := route.Interface + "/" + normalizeIP(ip)
+		hop, found := seen[key]
+		if !found {
+			hop = &nextHop{ip: ip, iface: route.Interface}
+			seen[key] = hop
+			ret = append(ret, hop)
+		}
+		hop.destinations = append(hop.destinations, dst.name)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no next hops found for the tested destinations")
+	}
+	return ret, nil
+}
+
+// RunNeighborCheck checks that the link layer address of the gateway & the other next hops
+// of the destinations tested in the scope can be resolved. The neighbor table entry of each
+// next hop is reported before & after triggering resolution by sending a datagram to it.
+// Fails if an entry is FAILED or INCOMPLETE once resolution is attempted
+func RunNeighborCheck(ctx context.Context, env *Env) Result {
+	var res Result
+	hops, err := env.nextHops(ctx)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Success = true
+	for _, hop := range hops {
+		name := fmt.Sprintf("%s dev %s (next hop of %s)", hop.ip, hop.iface, strings.Join(hop.destinations, ", "))
+		usesNeighbors, err := netutils.LinkUsesNeighbors(hop.iface)
+		if err != nil {
+			res.addDetail("%s: %v", name, err)
+			res.Success = false
+			continue
+		}
+		if !usesNeighbors {
+			res.addDetail("%s: link doesn't use neighbor resolution", name)
+			continue
+		}
+		before, err := netutils.LookupNeighbor(hop.iface, hop.ip)
+		if err != nil {
+			res.addDetail("%s: %v", name, err)
+			res.Success = false
+			continue
+		}
+		after, err := netutils.ResolveNeighbor(ctx, hop.iface, hop.ip)
+		if err != nil {
+			res.addDetail("%s: %s, resolution failed: %v", name, before.State, err)
+			res.Success = false
+			if ctx.Err() != nil {
+				res.Err = err
+				return res
+			}
+			continue
+		}
+		if !after.Resolved() {
+			res.addDetail("%s: %s -> %s. Link layer address not resolved", name, before.State, after.State)
+			res.Success = false
+			continue
+		}
+		res.addDetail("%s: %s -> %s lladdr %s", name, before.State, after.State, after.MAC)
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) neighbor resolution of %d next hops\n", len(hops))
+	} else {
+		env.Log.Debug("  (Failed) neighbor resolution of %d next hops\n", len(hops))
+	}
+	return res
+}
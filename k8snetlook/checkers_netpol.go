@@ -0,0 +1,157 @@
+package k8snetlook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+func init() {
+	Register(&checker{
+		name:          "dstpod-netpol",
+		description:   "NetworkPolicy evaluation for SrcPod to DstPod traffic",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstPod},
+		run: func(ctx context.Context, env *Env) Result {
+			return RunNetworkPolicyCheck(ctx, env)
+		},
+	})
+}
+
+// RunNetworkPolicyCheck evaluates the ingress policies of the DstPod & the egress policies of
+// the SrcPod for traffic without ports (eg: ICMP) & each of the DstPod's container ports.
+// Passes if policies allow all of it
+func RunNetworkPolicyCheck(ctx context.Context, env *Env) Result {
+	var res Result
+	eval, err := env.newPolicyEvaluator(ctx)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	ports := append([]Port{{}}, env.Cfg.DstPod.Ports...)
+	res.Success = true
+	for i, port := range ports {
+		ingress, egress := eval.evaluate(port)
+		if i == 0 {
+			res.addDetail("DstPod ingress: %s", describeIsolation(ingress))
+			res.addDetail("SrcPod egress: %s", describeIsolation(egress))
+		}
+		if !ingress.Allowed || !egress.Allowed {
+			res.Success = false
+		}
+		res.addDetail("%s: %s", describePolicyPort(port), describeVerdicts(ingress, egress))
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) NetworkPolicies allow traffic from SrcPod to DstPod\n")
+	} else {
+		env.Log.Debug("  (Failed) NetworkPolicies deny traffic from SrcPod to DstPod\n")
+	}
+	return res
+}
+
+// annotatePolicyDenials adds the policies that deny traffic to each of the ports to the details
+// of a failed connectivity check. A zero port stands for traffic without ports, eg: ICMP
+func annotatePolicyDenials(ctx context.Context, env *Env, res *Result, ports []Port) {
+	if res.Success || env.Cfg.SrcPod.Name == "" || env.Cfg.DstPod.Name == "" {
+		return
+	}
+	eval, err := env.newPolicyEvaluator(ctx)
+	if err != nil {
+		env.Log.Debug("Unable to evaluate networkpolicies. Error: %v", err)
+		return
+	}
+	for _, port := range ports {
+		ingress, egress := eval.evaluate(port)
+		if ingress.Allowed && egress.Allowed {
+			continue
+		}
+		res.addDetail("%s: expected: %s", describePolicyPort(port), describeVerdicts(ingress, egress))
+	}
+}
+
+// policyEvaluator evaluates the NetworkPolicies of the SrcPod & DstPod namespaces
+type policyEvaluator struct {
+	policies []networkingv1.NetworkPolicy
+	src, dst policyPod
+}
+
+// newPolicyEvaluator fetches the NetworkPolicies & namespace labels needed to evaluate
+// traffic from the SrcPod to the DstPod
+func (e *Env) newPolicyEvaluator(ctx context.Context) (*policyEvaluator, error) {
+	src, dst := e.Cfg.SrcPod, e.Cfg.DstPod
+	policies, err := e.getNetworkPolicies(ctx, src.Namespace, dst.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch networkpolicies: %v", err)
+	}
+	eval := &policyEvaluator{
+		policies: policies,
+		src:      policyPod{Namespace: src.Namespace, IP: src.IP, Labels: src.Labels},
+		dst:      policyPod{Namespace: dst.Namespace, IP: dst.IP, Labels: dst.Labels},
+	}
+	if eval.src.NamespaceLabels, err = e.getNamespaceLabels(ctx, src.Namespace); err != nil {
+		return nil, fmt.Errorf("unable to fetch namespace %s: %v", src.Namespace, err)
+	}
+	if eval.dst.NamespaceLabels, err = e.getNamespaceLabels(ctx, dst.Namespace); err != nil {
+		return nil, fmt.Errorf("unable to fetch namespace %s: %v", dst.Namespace, err)
+	}
+	return eval, nil
+}
+
+// evaluate returns the ingress & egress verdicts for traffic to port
+func (p *policyEvaluator) evaluate(port Port) (policyVerdict, policyVerdict) {
+	return evaluateNetworkPolicies(p.policies, p.src, p.dst, port)
+}
+
+// describeIsolation lists the policies that select a pod
+func describeIsolation(v policyVerdict) string {
+	if !v.Isolated {
+		return "not selected by any policy. All traffic allowed"
+	}
+	return "selected by " + strings.Join(v.Selecting, ", ")
+}
+
+// describeVerdicts describes whether traffic is allowed & by which policies
+func describeVerdicts(ingress, egress policyVerdict) string {
+	var denied []string
+	if !egress.Allowed {
+		denied = append(denied, "egress denied by policy "+strings.Join(egress.Selecting, ", "))
+	}
+	if !ingress.Allowed {
+		denied = append(denied, "ingress denied by policy "+strings.Join(ingress.Selecting, ", "))
+	}
+	if len(denied) > 0 {
+		return strings.Join(denied, "; ")
+	}
+	var allowed []string
+	if egress.Isolated {
+		allowed = append(allowed, "egress allowed by "+strings.Join(egress.AllowedBy, ", "))
+	}
+	if ingress.Isolated {
+		allowed = append(allowed, "ingress allowed by "+strings.Join(ingress.AllowedBy, ", "))
+	}
+	if len(allowed) == 0 {
+		return "allowed"
+	}
+	return "allowed: " + strings.Join(allowed, "; ")
+}
+
+// describePolicyPort describes the port traffic is evaluated for
+func describePolicyPort(port Port) string {
+	if port.Port == 0 {
+		return "traffic without ports (icmp)"
+	}
+	return port.String()
+}
+
+// portsWithProtocol returns the ports using protocol
+func portsWithProtocol(ports []Port, protocol string) []Port {
+	var ret []Port
+	for _, port := range ports {
+		if port.Protocol == protocol {
+			ret = append(ret, port)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,153 @@
+package k8snetlook
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sarun87/k8snetlook/netutils"
+	"github.com/vishvananda/netns"
+)
+
+func init() {
+	Register(&checker{
+		name:        "routes",
+		description: "Route & policy routing check for tested destinations",
+		scope:       ScopeHost | ScopePod,
+		run: func(ctx context.Context, env *Env) Result {
+			return RunRouteCheck(ctx, env)
+		},
+	})
+}
+
+// destination is an IP the checks of a scope send traffic to
+type destination struct {
+	name string
+	ip   string
+}
+
+// gatewayIP returns the default gateway of the netns of the environment
+func (e *Env) gatewayIP() string {
+	if e.Scope == ScopeHost {
+		return e.Cfg.HostGatewayIP
+	}
+	gw, err := netutils.GetHostGatewayIP(e.Log)
+	if err != nil {
+		e.Log.Debug("Unable to find the default gateway of the pod. Error: %v", err)
+	}
+	return gw
+}
+
+// destinations returns the destinations tested by the checks of the environment. Each
+// IP is listed once
+func (e *Env) destinations(ctx context.Context) []destination {
+	var ret []destination
+	seen := map[string]bool{}
+	add := func(name, ip string) {
+		if ip == "" || ip == "None" || seen[normalizeIP(ip)] {
+			return
+		}
+		seen[normalizeIP(ip)] = true
+		ret = append(ret, destination{name: name, ip: ip})
+	}
+	add("default gateway", e.gatewayIP())
+	add("kube-apiserver service", e.Cfg.KubeAPIService.IP)
+	apiEndpoints, _ := splitEndpoints(e.getEndpointsFromService(ctx, "default", "kubernetes"))
+	for _, ep := range apiEndpoints {
+		add("kube-apiserver endpoint", ep.IP)
+	}
+	if e.Scope == ScopePod {
+		add("kube-dns service", e.Cfg.KubeDNSService.IP)
+	}
+	add("DstPod", e.Cfg.DstPod.IP)
+	for _, clusterIP := range e.Cfg.DstSvc.clusterIPs() {
+		add("DstSvc", clusterIP)
+	}
+	add("ExternalIP", e.Cfg.ExternalIP)
+	return ret
+}
+
+// RunRouteCheck looks up the route the kernel picks for each destination tested in the
+// scope, including the policy routing rule & table. Pod checks also look up the route of
+// the host. Fails if a destination has no route or matches a blackhole, unreachable or
+// prohibit route. For pod checks, replies to the source IP of the pod that the host doesn't
+// route via the veth peer of the interface the pod sends traffic out of, or the bridge the
+// peer is a port of, are reported. The path isn't failed as eBPF based CNI plugins such as
+// Cilium route pod subnets via their own device & redirect replies to the veth
+func RunRouteCheck(ctx context.Context, env *Env) Result {
+	var res Result
+	destinations := env.destinations(ctx)
+	if len(destinations) == 0 {
+		res.Err = fmt.Errorf("no destinations found to look up routes for")
+		return res
+	}
+	res.Success = true
+	// Routes of the host back to the source IPs of the pod
+	returnRoutes := map[string]netutils.RouteInfo{}
+	// Bridges the host ends of the veths of the pod are ports of, keyed by veth index
+	masters := map[int]int{}
+	for _, dst := range destinations {
+		route, err := netutils.GetRoute(netns.None(), dst.ip)
+		if !checkRoute(&res, env.Scope.String(), dst, route, err) {
+			res.Success = false
+		}
+		if env.Scope != ScopePod {
+			continue
+		}
+		hostRoute, err := netutils.GetRoute(env.HostNsHandle, dst.ip)
+		if !checkRoute(&res, ScopeHost.String(), dst, hostRoute, err) {
+			res.Success = false
+		}
+		if err != nil || route.Type != "unicast" || route.PeerIndex == 0 || route.Src == "" {
+			continue
+		}
+		returnRoute, found := returnRoutes[route.Src]
+		if !found {
+			if returnRoute, err = netutils.GetRoute(env.HostNsHandle, route.Src); err != nil {
+				res.addDetail("%s %s: unable to look up the host route to %s: %v", dst.name, dst.ip, route.Src, err)
+				continue
+			}
+			returnRoutes[route.Src] = returnRoute
+		}
+		masterIndex, found := masters[route.PeerIndex]
+		if !found {
+			if masterIndex, err = netutils.GetMasterIndex(env.HostNsHandle, route.PeerIndex); err != nil {
+				env.Log.Debug("Unable to look up host peer of %s. Error: %v", route.Interface, err)
+			}
+			masters[route.PeerIndex] = masterIndex
+		}
+		if !symmetricPath(returnRoute, route.PeerIndex, masterIndex) {
+			// Expected with eBPF datapaths. Otherwise replies may be dropped by reverse path
+			// filtering or never reach the pod
+			res.addDetail("%s %s: traffic from %s leaves the pod via %s (host peer index %d) but the host routes replies via %s (index %d). Expected with eBPF CNIs such as Cilium",
+				dst.name, dst.ip, route.Src, route.Interface, route.PeerIndex, returnRoute.Interface, returnRoute.LinkIndex)
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) routes to %d destinations\n", len(destinations))
+	} else {
+		env.Log.Debug("  (Failed) routes to %d destinations\n", len(destinations))
+	}
+	return res
+}
+
+// symmetricPath checks if the host routes replies to the pod via the host end of the veth
+// the pod sends traffic out of, or via the bridge with index masterIndex the veth is a port
+// of. Eg: bridge & OVS based CNI plugins route the pod subnet via the bridge
+func symmetricPath(returnRoute netutils.RouteInfo, peerIndex, masterIndex int) bool {
+	return returnRoute.LinkIndex == peerIndex || (masterIndex != 0 && returnRoute.LinkIndex == masterIndex)
+}
+
+// checkRoute adds the route to dst looked up in the netns named from to res. Returns false
+// if packets to dst are dropped
+func checkRoute(res *Result, from string, dst destination, route netutils.RouteInfo, err error) bool {
+	switch {
+	case err != nil:
+		res.addDetail("%s %s: %s: no route: %v", dst.name, dst.ip, from, err)
+		return false
+	case route.Unroutable():
+		res.addDetail("%s %s: %s: dropped by %s", dst.name, dst.ip, from, route)
+		return false
+	}
+	res.addDetail("%s %s: %s: %s", dst.name, dst.ip, from, route)
+	return true
+}
@@ -0,0 +1,196 @@
+package k8snetlook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sarun87/k8snetlook/netutils"
+)
+
+const (
+	// clusterIPProbesPerEndpoint is the number of connections opened to a ClusterIP
+	// port per endpoint. kube-proxy picks endpoints at random, so more connections
+	// make it likelier for every endpoint to be picked at least once
+	clusterIPProbesPerEndpoint = 5
+	maxClusterIPProbes         = 50
+)
+
+func init() {
+	Register(&checker{
+		name:          "dstsvc-clusterip",
+		description:   "DstSvc ClusterIP load balancing check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstSvcClusterIP},
+		run: func(ctx context.Context, env *Env) Result {
+			res := RunClusterIPLoadBalancingCheck(ctx, env, env.Cfg.DstSvc)
+			addNotReadyDetails(&res, env.Cfg.DstSvc)
+			return res
+		},
+	})
+}
+
+// RunClusterIPLoadBalancingCheck opens repeated connections to every port of the service
+// ClusterIP. The endpoint each connection was load balanced to is looked up using the
+// host conntrack table. Fails if connections fail or if some endpoints are never reached
+func RunClusterIPLoadBalancingCheck(ctx context.Context, env *Env, svc Service) Result {
+	var res Result
+	probeCount := len(svc.SvcEndpoints) * clusterIPProbesPerEndpoint
+	if probeCount == 0 {
+		probeCount = clusterIPProbesPerEndpoint
+	}
+	if probeCount > maxClusterIPProbes {
+		probeCount = maxClusterIPProbes
+	}
+	res.Success = true
+	for _, clusterIP := range svc.clusterIPs() {
+		endpoints := endpointsOfFamily(svc.SvcEndpoints, clusterIP)
+		for _, port := range svc.Ports {
+			if !probeClusterIPPort(ctx, env, clusterIP, port, endpointAddrs(endpoints, port), probeCount, &res) {
+				res.Success = false
+			}
+			if ctx.Err() != nil {
+				res.Success = false
+				res.Err = ctx.Err()
+				return res
+			}
+		}
+	}
+	if res.Success {
+		env.Log.Debug("  (Passed) ClusterIP load balancing check")
+	} else {
+		env.Log.Debug("  (Failed) ClusterIP load balancing check")
+	}
+	return res
+}
+
+// endpointAddrs returns the "ip:port" of the endpoints that traffic to the service
+// port is expected to be sent to. Endpoint ports share the name of the service port
+func endpointAddrs(endpoints []Endpoint, svcPort Port) map[string]bool {
+	ret := map[string]bool{}
+	for _, ep := range endpoints {
+		for _, port := range ep.Ports {
+			if port.Name == svcPort.Name && port.Protocol == svcPort.Protocol {
+				ret[net.JoinHostPort(ep.IP, strconv.Itoa(int(port.Port)))] = true
+			}
+		}
+	}
+	return ret
+}
+
+// endpointsOfFamily returns the endpoints of the IP family of ip. Dual-stack services
+// have endpoints of both families, but a ClusterIP only sends traffic to its own family
+func endpointsOfFamily(endpoints []Endpoint, ip string) []Endpoint {
+	ipv4 := net.ParseIP(ip).To4() != nil
+	var ret []Endpoint
+	for _, ep := range endpoints {
+		if (net.ParseIP(ep.IP).To4() != nil) == ipv4 {
+			ret = append(ret, ep)
+		}
+	}
+	return ret
+}
+
+// probeClusterIPPort opens probeCount connections to clusterIP:port & adds the outcome to res.
+// endpoints holds the "ip:port" of the endpoints the connections are expected to reach
+func probeClusterIPPort(ctx context.Context, env *Env, clusterIP string, port Port, endpoints map[string]bool, probeCount int, res *Result) bool {
+	addr := net.JoinHostPort(clusterIP, strconv.Itoa(int(port.Port)))
+	var flows []netutils.Flow
+	failedCount := 0
+	for i := 0; i < probeCount && ctx.Err() == nil; i++ {
+		var localAddr string
+		switch port.Protocol {
+		case "TCP":
+			conn, err := netutils.TCPConnect(ctx, clusterIP, port.Port)
+			if err != nil || conn.State != netutils.TCPConnected {
+				env.Log.Debug("    connection to %s failed. State: %s Error: %v\n", addr, conn.State, err)
+				failedCount++
+				continue
+			}
+			localAddr = conn.LocalAddr
+		case "UDP":
+			var err error
+			if localAddr, err = netutils.SendUDPDatagram(ctx, clusterIP, port.Port, nil); err != nil {
+				env.Log.Debug("    %v\n", err)
+				failedCount++
+				continue
+			}
+		default:
+			res.addDetail("%s: protocol not supported", port)
+			return true
+		}
+		if flow, err := netutils.NewFlow(port.Protocol, localAddr, addr); err == nil {
+			flows = append(flows, flow)
+		}
+		env.recordFlow(port.Protocol, localAddr, addr, port.Protocol == "TCP")
+	}
+	pass := failedCount == 0
+	res.addDetail("%s: %d/%d connections to %s succeeded", port, probeCount-failedCount, probeCount, addr)
+
+	// Find out which endpoint kube-proxy DNAT'ed each of the connections to
+	entries, err := netutils.LookupConntrack(env.HostNsHandle, flows)
+	if err != nil {
+		env.Log.Debug("    unable to lookup conntrack entries. Error: %v\n", err)
+	}
+	backends := map[string]int{}
+	reached := map[string]bool{}
+	for _, flow := range flows {
+		if entry, found := entries[flow]; found && entry.DNATed() {
+			backends[entry.ReplySrc()]++
+			reached[entry.ReplySrc()] = true
+		}
+	}
+	if len(flows) > 0 && len(backends) == 0 {
+		res.addDetail("%s: endpoints picked could not be determined using conntrack", port)
+		return pass
+	}
+	res.addDetail("%s: endpoints picked: %s", port, formatCounts(backends))
+	var unreached []string
+	for addr := range endpoints {
+		if !reached[addr] {
+			unreached = append(unreached, addr)
+		}
+	}
+	if len(unreached) > 0 {
+		sort.Strings(unreached)
+		res.addDetail("%s: endpoints never reached: %s. kube-proxy rules may be stale", port, strings.Join(unreached, ", "))
+		pass = false
+	}
+	var unknown []string
+	for addr := range reached {
+		if !endpoints[addr] {
+			unknown = append(unknown, addr)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		res.addDetail("%s: connections sent to addresses that are not endpoints: %s. kube-proxy rules may be stale", port, strings.Join(unknown, ", "))
+		pass = false
+	}
+	return pass
+}
+
+// addNotReadyDetails adds the endpoints of the service that aren't ready to the details of res.
+// Such endpoints aren't expected to receive traffic & are excluded from connectivity checks
+func addNotReadyDetails(res *Result, svc Service) {
+	for _, ep := range svc.NotReadyEndpoints {
+		res.addDetail("%s: skipped (%s)", ep.IP, ep.conditions())
+	}
+}
+
+// formatCounts formats a map of counts as "key x count" sorted by key
+func formatCounts(counts map[string]int) string {
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var ret []string
+	for _, key := range keys {
+		ret = append(ret, fmt.Sprintf("%s x%d", key, counts[key]))
+	}
+	return strings.Join(ret, ", ")
+}
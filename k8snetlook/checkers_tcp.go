@@ -0,0 +1,92 @@
+package k8snetlook
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/sarun87/k8snetlook/netutils"
+)
+
+func init() {
+	Register(&checker{
+		name:          "dstpod-tcp",
+		description:   "DstPod TCP port connectivity check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstPod},
+		run: func(ctx context.Context, env *Env) Result {
+			targets := []Endpoint{{IP: env.Cfg.DstPod.IP, Ports: env.Cfg.DstPod.Ports}}
+			res := RunTCPConnectivityCheck(ctx, env, targets)
+			annotatePolicyDenials(ctx, env, &res, portsWithProtocol(env.Cfg.DstPod.Ports, "TCP"))
+			return res
+		},
+	})
+	Register(&checker{
+		name:          "dstsvc-endpoints-tcp",
+		description:   "DstSvc Endpoints TCP connectivity check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstSvc},
+		run: func(ctx context.Context, env *Env) Result {
+			res := RunTCPConnectivityCheck(ctx, env, env.Cfg.DstSvc.SvcEndpoints)
+			addNotReadyDetails(&res, env.Cfg.DstSvc)
+			return res
+		},
+	})
+}
+
+// RunTCPConnectivityCheck opens a TCP connection to each of the TCP ports of the targets.
+// Passes if connections to all of the ports succeed. Non-TCP ports are ignored. Inconclusive
+// if the targets have no TCP ports
+func RunTCPConnectivityCheck(ctx context.Context, env *Env, targets []Endpoint) Result {
+	var res Result
+	totalCount, passedCount := 0, 0
+	for _, target := range targets {
+		for _, port := range target.Ports {
+			if port.Protocol != "TCP" {
+				continue
+			}
+			totalCount++
+			addr := net.JoinHostPort(target.IP, strconv.Itoa(int(port.Port)))
+			env.Log.Debug("  connecting to %s ........", addr)
+			conn, err := netutils.TCPConnect(ctx, target.IP, port.Port)
+			if err != nil {
+				env.Log.Debug("    (Failed) Error connecting to %s. Error: %v\n", addr, err)
+				res.addDetail("%s: %s: error: %v", port, addr, err)
+				if ctx.Err() != nil {
+					res.Err = err
+					return res
+				}
+				continue
+			}
+			env.recordFlow(port.Protocol, conn.LocalAddr, addr, true)
+			switch conn.State {
+			case netutils.TCPConnected:
+				env.Log.Debug("    (Passed) connected to %s in %v\n", addr, conn.RTT)
+				res.addDetail("%s: %s: connected in %v", port, addr, conn.RTT)
+				passedCount++
+			case netutils.TCPRefused:
+				env.Log.Debug("    (Failed) connection to %s refused (RST) in %v\n", addr, conn.RTT)
+				res.addDetail("%s: %s: refused (RST) in %v. Nothing listening on port or rejected by policy", port, addr, conn.RTT)
+			case netutils.TCPTimeout:
+				env.Log.Debug("    (Failed) connection to %s timed out after %v\n", addr, conn.RTT)
+				res.addDetail("%s: %s: timed out after %v. Packets likely dropped", port, addr, conn.RTT)
+			default:
+				env.Log.Debug("    (Failed) %s %s after %v\n", addr, conn.State, conn.RTT)
+				res.addDetail("%s: %s: %s after %v", port, addr, conn.State, conn.RTT)
+			}
+		}
+	}
+	if totalCount == 0 {
+		// Eg: pods without declared containerPorts
+		res.addDetail("no TCP ports found to connect to")
+		res.Inconclusive = true
+		return res
+	}
+	res.Success = passedCount == totalCount
+	if res.Success {
+		env.Log.Debug("  (Passed) TCP connectivity check to %d ports", totalCount)
+	} else {
+		env.Log.Debug("  (Failed) TCP connectivity check for %d/%d ports", totalCount-passedCount, totalCount)
+	}
+	return res
+}
@@ -0,0 +1,494 @@
+package k8snetlook
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/dns"
+	log "github.com/sarun87/k8snetlook/logutil"
+	"github.com/sarun87/k8snetlook/netutils"
+	"github.com/vishvananda/netns"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestEnv returns an environment for running checkers from the current netns
+func newTestEnv(cfg *Config, scope Scope) *Env {
+	return &Env{Cfg: cfg, Client: fake.NewSimpleClientset(), Log: log.New(log.ERROR), Scope: scope, HostNsHandle: netns.None()}
+}
+
+func TestTCPConnectivityCheckWithoutPorts(t *testing.T) {
+	env := newTestEnv(&Config{}, ScopePod)
+	targets := []Endpoint{{IP: "127.0.0.1", Ports: []Port{{Port: 53, Protocol: "UDP"}}}}
+	res := RunTCPConnectivityCheck(context.Background(), env, targets)
+	if res.Success || !res.Inconclusive || res.Err != nil {
+		t.Errorf("Expected inconclusive result for targets without TCP ports. Got: %+v", res)
+	}
+}
+
+func TestEndpointAddrs(t *testing.T) {
+	endpoints := []Endpoint{
+		{IP: "10.244.1.5", Ports: []Port{{Name: "http", Port: 8080, Protocol: "TCP"}, {Name: "dns", Port: 5353, Protocol: "UDP"}}},
+		{IP: "fd00::5", Ports: []Port{{Name: "http", Port: 8080, Protocol: "TCP"}}},
+		{IP: "10.244.1.6", Ports: []Port{{Name: "dns", Port: 5353, Protocol: "TCP"}}},
+	}
+	addrs := endpointAddrs(endpoints, Port{Name: "http", Port: 80, Protocol: "TCP"})
+	if len(addrs) != 2 || !addrs["10.244.1.5:8080"] || !addrs["[fd00::5]:8080"] {
+		t.Errorf("Unexpected endpoints for http port: %v", addrs)
+	}
+	// Protocol must match along with the name
+	addrs = endpointAddrs(endpoints, Port{Name: "dns", Port: 53, Protocol: "UDP"})
+	if len(addrs) != 1 || !addrs["10.244.1.5:5353"] {
+		t.Errorf("Unexpected endpoints for dns port: %v", addrs)
+	}
+}
+
+func TestSelectCheckersHeadlessService(t *testing.T) {
+	cfg := &Config{DstSvc: Service{Name: "web", Namespace: "default", ClusterIP: "None"}}
+	names := checkerNames(selectCheckers(newTestEnv(cfg, ScopePod)))
+	if containsString(names, "dstsvc-clusterip") || !containsString(names, "dstsvc-endpoints") {
+		t.Errorf("Expected ClusterIP checks not to be selected for headless service. Got: %v", names)
+	}
+	names = checkerNames(selectCheckers(newTestEnv(cfg, ScopeHost)))
+	if containsString(names, "dstsvc-iptables") {
+		t.Errorf("Expected kube-proxy rules check not to be selected for headless service. Got: %v", names)
+	}
+}
+
+func TestUDPReachabilityCheckWithoutPorts(t *testing.T) {
+	env := newTestEnv(&Config{}, ScopePod)
+	targets := []Endpoint{{IP: "127.0.0.1", Ports: []Port{{Port: 80, Protocol: "TCP"}}}}
+	res := RunUDPReachabilityCheck(context.Background(), env, targets)
+	if res.Success || !res.Inconclusive || res.Err != nil {
+		t.Errorf("Expected inconclusive result for targets without UDP ports. Got: %+v", res)
+	}
+}
+
+// startTestDNSServer serves DNS over UDP on a local port using handler. Returns ip:port
+func startTestDNSServer(t *testing.T, handler dns.HandlerFunc) string {
+	return startTestDNSServerOn(t, "127.0.0.1:0", handler)
+}
+
+// startTestDNSServerOn serves DNS over UDP on addr using handler. Returns ip:port
+func startTestDNSServerOn(t *testing.T, addr string, handler dns.HandlerFunc) string {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatalf("Unable to listen on udp: %v", err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// listenTCP accepts connections on a local port until the test ends. Returns the port
+func listenTCP(t *testing.T) int32 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen on tcp: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return int32(p)
+}
+
+func TestNodePortConnectivityCheck(t *testing.T) {
+	nodePort := listenTCP(t)
+	// Connections to node-b are refused since nothing listens on 127.0.0.2
+	nodes := []Node{{Name: "node-a", IP: "127.0.0.1"}, {Name: "node-b", IP: "127.0.0.2"}}
+	svc := Service{
+		Name:         "web",
+		Namespace:    "default",
+		Type:         "NodePort",
+		Ports:        []Port{{Port: 80, Protocol: "TCP", NodePort: nodePort}},
+		SvcEndpoints: []Endpoint{{IP: "10.244.0.5", NodeName: "node-a"}},
+	}
+	tests := []struct {
+		name        string
+		policy      string
+		localNode   string
+		wantSuccess bool
+	}{
+		{name: "policy Cluster", policy: "Cluster", localNode: "node-a", wantSuccess: false},
+		{name: "policy Local, remote node without endpoints", policy: "Local", localNode: "node-a", wantSuccess: true},
+		// Traffic originating on the local node is expected to be forwarded
+		{name: "policy Local, local node without endpoints", policy: "Local", localNode: "node-b", wantSuccess: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc.ExternalTrafficPolicy = tt.policy
+			env := newTestEnv(&Config{Nodes: nodes, LocalNodeName: tt.localNode}, ScopeHost)
+			res := RunNodePortConnectivityCheck(context.Background(), env, svc)
+			if res.Err != nil || res.Success != tt.wantSuccess {
+				t.Errorf("Expected success %v. Got: %+v", tt.wantSuccess, res)
+			}
+		})
+	}
+
+	svc.Ports[0].NodePort = 0
+	res := RunNodePortConnectivityCheck(context.Background(), newTestEnv(&Config{Nodes: nodes}, ScopeHost), svc)
+	if res.Err == nil {
+		t.Errorf("Expected error for service without NodePorts. Got: %+v", res)
+	}
+}
+
+func TestSelectCheckersLoadBalancerWithoutNodePorts(t *testing.T) {
+	cfg := &Config{DstSvc: Service{
+		Name:      "web",
+		Namespace: "default",
+		Type:      "LoadBalancer",
+		ClusterIP: "10.96.0.20",
+		Ports:     []Port{{Port: 80, Protocol: "TCP"}},
+	}}
+	names := checkerNames(selectCheckers(newTestEnv(cfg, ScopeHost)))
+	if containsString(names, "dstsvc-nodeport") || !containsString(names, "dstsvc-loadbalancer") {
+		t.Errorf("Expected NodePort check not to be selected with allocateLoadBalancerNodePorts false. Got: %v", names)
+	}
+	cfg.DstSvc.Ports[0].NodePort = 30080
+	names = checkerNames(selectCheckers(newTestEnv(cfg, ScopeHost)))
+	if !containsString(names, "dstsvc-nodeport") {
+		t.Errorf("Expected NodePort check to be selected. Got: %v", names)
+	}
+}
+
+func TestLoadBalancerConnectivityCheck(t *testing.T) {
+	port := listenTCP(t)
+	dnsServerURL := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		switch {
+		case q.Name != "lb.example.com.":
+			m.Rcode = dns.RcodeNameError
+		case q.Qtype == dns.TypeA:
+			rr, _ := dns.NewRR(q.Name + " 30 IN A 127.0.0.1")
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	})
+	svc := Service{Name: "web", Namespace: "default", Type: "LoadBalancer", Ports: []Port{{Port: port, Protocol: "TCP"}}}
+	env := newTestEnv(&Config{}, ScopeHost)
+
+	res := runLoadBalancerConnectivityCheck(context.Background(), env, dnsServerURL, svc)
+	if res.Err == nil {
+		t.Errorf("Expected error for service without load balancer ingress. Got: %+v", res)
+	}
+	svc.LoadBalancerIngress = []string{"127.0.0.1", "lb.example.com"}
+	res = runLoadBalancerConnectivityCheck(context.Background(), env, dnsServerURL, svc)
+	if res.Err != nil || !res.Success {
+		t.Errorf("Expected ingress IP & hostname to be reachable. Got: %+v", res)
+	}
+	svc.LoadBalancerIngress = []string{"missing.example.com"}
+	res = runLoadBalancerConnectivityCheck(context.Background(), env, dnsServerURL, svc)
+	if res.Err != nil || res.Success {
+		t.Errorf("Expected failure for ingress hostname that doesn't resolve. Got: %+v", res)
+	}
+}
+
+func TestExternalNameResolutionCheck(t *testing.T) {
+	dnsServerURL := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		target, _ := dns.NewRR("db.example.com. 30 IN A 192.0.2.10")
+		switch q.Name {
+		case "db.default.svc.cluster.local.":
+			if q.Qtype == dns.TypeA {
+				cname, _ := dns.NewRR(q.Name + " 30 IN CNAME db.example.com.")
+				m.Answer = append(m.Answer, cname, target)
+			}
+		case "db.example.com.", "stale.example.com.":
+			if q.Qtype == dns.TypeA {
+				m.Answer = append(m.Answer, target)
+			}
+		default:
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+	env := newTestEnv(&Config{}, ScopePod)
+	tests := []struct {
+		name         string
+		svcName      string
+		externalName string
+		wantSuccess  bool
+	}{
+		{name: "CNAME followed", svcName: "db", externalName: "db.example.com", wantSuccess: true},
+		// The external name resolves but cluster DNS has no record for the service
+		{name: "CNAME missing", svcName: "stale", externalName: "stale.example.com", wantSuccess: false},
+		{name: "external name missing", svcName: "missing", externalName: "missing.example.com", wantSuccess: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := Service{Name: tt.svcName, Namespace: "default", Type: "ExternalName", ExternalName: tt.externalName}
+			res := runExternalNameResolutionCheck(context.Background(), env, dnsServerURL, svc)
+			if res.Err != nil || res.Success != tt.wantSuccess {
+				t.Errorf("Expected success %v. Got: %+v", tt.wantSuccess, res)
+			}
+		})
+	}
+}
+
+// kubeDNSEndpoints returns the kube-dns Endpoints of a single replica serving on ip:port
+func kubeDNSEndpoints(ip string, port int32) *corev1.Endpoints {
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-dns"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: ip}},
+			Ports:     []corev1.EndpointPort{{Name: "dns", Port: port, Protocol: corev1.ProtocolUDP}},
+		}},
+	}
+}
+
+func TestDNSReplicasCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		answerPTR   bool
+		wantSuccess bool
+	}{
+		{name: "replica answers", answerPTR: true, wantSuccess: true},
+		{name: "replica without PTR record", answerPTR: false, wantSuccess: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dnsServerURL := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				m := new(dns.Msg)
+				m.SetReply(r)
+				q := r.Question[0]
+				switch {
+				case q.Name == "kubernetes.default.svc.cluster.local." && q.Qtype == dns.TypeA:
+					rr, _ := dns.NewRR(q.Name + " 30 IN A 10.96.0.1")
+					m.Answer = append(m.Answer, rr)
+				case q.Name == "1.0.96.10.in-addr.arpa." && tt.answerPTR:
+					rr, _ := dns.NewRR(q.Name + " 30 IN PTR kubernetes.default.svc.cluster.local.")
+					m.Answer = append(m.Answer, rr)
+				default:
+					m.Rcode = dns.RcodeNameError
+				}
+				w.WriteMsg(m)
+			})
+			host, port, _ := net.SplitHostPort(dnsServerURL)
+			portNum, _ := strconv.Atoi(port)
+			env := newTestEnv(&Config{KubeAPIService: Endpoint{IP: "10.96.0.1"}}, ScopePod)
+			env.Client = fake.NewSimpleClientset(kubeDNSEndpoints(host, int32(portNum)))
+			env.flows = &flowRecorder{}
+
+			res := RunDNSReplicasCheck(context.Background(), env)
+			if res.Err != nil || res.Success != tt.wantSuccess {
+				t.Errorf("Expected success %v. Got: %+v", tt.wantSuccess, res)
+			}
+			// The A & PTR queries are recorded for the conntrack check
+			if flows := env.recordedFlows(ScopePod); len(flows) != 2 {
+				t.Errorf("Expected 2 recorded flows. Got: %+v", flows)
+			}
+		})
+	}
+
+	res := RunDNSReplicasCheck(context.Background(), newTestEnv(&Config{}, ScopePod))
+	if res.Err == nil {
+		t.Errorf("Expected error without kube-dns endpoints. Got: %+v", res)
+	}
+}
+
+func TestNodeLocalDNSCheck(t *testing.T) {
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if q := r.Question[0]; q.Qtype == dns.TypeA {
+			rr, _ := dns.NewRR(q.Name + " 30 IN A 10.96.0.1")
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	}
+	// kube-dns is served on 127.0.0.1 & the cache on 127.0.0.2 using the same port
+	_, dnsPort, _ := net.SplitHostPort(startTestDNSServer(t, handler))
+	const kubeDNSIP, cacheIP = "127.0.0.1", "127.0.0.2"
+	tests := []struct {
+		name        string
+		cacheUp     bool
+		nameservers []string
+		wantSuccess bool
+	}{
+		{name: "cache answers", cacheUp: true, nameservers: []string{cacheIP}, wantSuccess: true},
+		{name: "pod uses cache that's down", cacheUp: false, nameservers: []string{cacheIP}, wantSuccess: false},
+		{name: "pod uses kube-dns while cache is down", cacheUp: false, nameservers: []string{kubeDNSIP}, wantSuccess: true},
+		{name: "pod uses neither", cacheUp: true, nameservers: []string{"192.0.2.53"}, wantSuccess: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cacheUp {
+				startTestDNSServerOn(t, net.JoinHostPort(cacheIP, dnsPort), handler)
+			}
+			cfg := &Config{
+				KubeAPIService: Endpoint{IP: "10.96.0.1"},
+				KubeDNSService: Endpoint{IP: kubeDNSIP},
+				NodeLocalDNS:   NodeLocalDNS{DaemonSet: "node-local-dns", IPs: []string{cacheIP}, Interface: true},
+			}
+			cfg.SrcPod.DNSConfig = &DNSConfig{Nameservers: tt.nameservers}
+			cfg.DNSRetries = -1
+
+			res := runNodeLocalDNSCheck(context.Background(), newTestEnv(cfg, ScopePod), dnsPort)
+			if res.Err != nil || res.Success != tt.wantSuccess {
+				t.Errorf("Expected success %v. Got: %+v", tt.wantSuccess, res)
+			}
+		})
+	}
+}
+
+func TestServiceDNSRecordsCheck(t *testing.T) {
+	records := map[string]string{
+		"_http._tcp.web.default.svc.cluster.local.":    "30 IN SRV 0 100 80 web.default.svc.cluster.local.",
+		"_postgres._tcp.db.default.svc.cluster.local.": "30 IN SRV 0 100 5432 db-0.db.default.svc.cluster.local.",
+		"20.0.96.10.in-addr.arpa.":                     "30 IN PTR web.default.svc.cluster.local.",
+		"5.1.244.10.in-addr.arpa.":                     "30 IN PTR web-0.web.default.svc.cluster.local.",
+		"6.1.244.10.in-addr.arpa.":                     "30 IN PTR db-0.db.default.svc.cluster.local.",
+		// Pod backing several services
+		"7.1.244.10.in-addr.arpa.": "30 IN PTR 10-244-1-7.other.default.svc.cluster.local.",
+	}
+	dnsServerURL := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		if record, found := records[q.Name]; found {
+			rr, _ := dns.NewRR(q.Name + " " + record)
+			m.Answer = append(m.Answer, rr)
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+	env := newTestEnv(&Config{}, ScopePod)
+	httpPort := Port{Name: "http", Port: 80, Protocol: "TCP"}
+	tests := []struct {
+		name        string
+		svc         Service
+		wantSuccess bool
+	}{
+		{
+			name: "ClusterIP service",
+			svc: Service{Name: "web", Namespace: "default", ClusterIP: "10.96.0.20", Ports: []Port{httpPort},
+				SvcEndpoints: []Endpoint{{IP: "10.244.1.5", Hostname: "web-0"}, {IP: "10.244.1.7"}}},
+			wantSuccess: true,
+		},
+		{
+			name: "headless service",
+			svc: Service{Name: "db", Namespace: "default", ClusterIP: "None", Ports: []Port{{Name: "postgres", Port: 5432, Protocol: "TCP"}},
+				SvcEndpoints: []Endpoint{{IP: "10.244.1.6", Hostname: "db-0", Ports: []Port{{Name: "postgres", Port: 5432, Protocol: "TCP"}}}}},
+			wantSuccess: true,
+		},
+		{
+			name: "SRV record of another port",
+			svc: Service{Name: "web", Namespace: "default", ClusterIP: "10.96.0.20", Ports: []Port{{Name: "http", Port: 8080, Protocol: "TCP"}},
+				SvcEndpoints: []Endpoint{{IP: "10.244.1.5", Hostname: "web-0"}}},
+			wantSuccess: false,
+		},
+		{
+			name: "missing PTR record",
+			svc: Service{Name: "web", Namespace: "default", ClusterIP: "10.96.0.20", Ports: []Port{httpPort},
+				SvcEndpoints: []Endpoint{{IP: "10.244.1.9"}}},
+			wantSuccess: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runServiceDNSRecordsCheck(context.Background(), env, dnsServerURL, tt.svc)
+			if res.Err != nil || res.Success != tt.wantSuccess {
+				t.Errorf("Expected success %v. Got: %+v", tt.wantSuccess, res)
+			}
+		})
+	}
+}
+
+func TestKubeProxyIPTablesCheckWithoutIPTables(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	svc := Service{Name: "web", Namespace: "default", ClusterIP: "10.96.0.20", Ports: []Port{{Name: "http", Port: 80, Protocol: "TCP"}}}
+	res := RunKubeProxyIPTablesCheck(context.Background(), newTestEnv(&Config{}, ScopeHost), svc)
+	if res.Err != nil || !res.Inconclusive || res.Success {
+		t.Errorf("Expected inconclusive result without iptables-save. Got: %+v", res)
+	}
+}
+
+func TestCheckConntrackEntry(t *testing.T) {
+	dnsFlow := netutils.Flow{Protocol: "UDP", SrcIP: "10.244.1.7", SrcPort: 40000, DstIP: "10.96.0.10", DstPort: 53}
+	backends := map[string]map[string]bool{"10.96.0.10": {"10.244.2.3": true}}
+	// IPS_SEEN_REPLY is bit 1 of the entry status
+	replied, unreplied := uint32(1<<1|1<<3), uint32(1<<3)
+	tests := []struct {
+		name        string
+		expectReply bool
+		entry       netutils.ConntrackEntry
+		found       bool
+		want        bool
+	}{
+		{name: "DNAT'ed to ready endpoint", expectReply: true, found: true, want: true,
+			entry: netutils.ConntrackEntry{Flow: dnsFlow, ReplySrcIP: "10.244.2.3", ReplySrcPort: 53, Status: replied}},
+		{name: "DNAT'ed to removed endpoint", expectReply: true, found: true, want: false,
+			entry: netutils.ConntrackEntry{Flow: dnsFlow, ReplySrcIP: "10.244.2.9", ReplySrcPort: 53, Status: replied}},
+		{name: "unreplied", expectReply: true, found: true, want: false,
+			entry: netutils.ConntrackEntry{Flow: dnsFlow, ReplySrcIP: "10.244.2.3", ReplySrcPort: 53, Status: unreplied}},
+		// UDP datagrams that services may ignore
+		{name: "unreplied, reply not expected", expectReply: false, found: true, want: true,
+			entry: netutils.ConntrackEntry{Flow: dnsFlow, ReplySrcIP: "10.244.2.3", ReplySrcPort: 53, Status: unreplied}},
+		{name: "expired", expectReply: true, found: false, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res Result
+			f := probeFlow{check: "dns-kube-dns", scope: ScopePod, flow: dnsFlow, expectReply: tt.expectReply}
+			if got := checkConntrackEntry(&res, f, tt.entry, tt.found, backends); got != tt.want {
+				t.Errorf("Expected %v. Got: %v, details: %v", tt.want, got, res.Details)
+			}
+		})
+	}
+}
+
+func TestSymmetricPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		returnRoute netutils.RouteInfo
+		masterIndex int
+		want        bool
+	}{
+		// Eg: calico routes each pod IP via the host end of its veth
+		{name: "routed via peer", returnRoute: netutils.RouteInfo{Interface: "veth1234", LinkIndex: 12}, want: true},
+		{name: "routed via bridge of peer", returnRoute: netutils.RouteInfo{Interface: "cni0", LinkIndex: 4}, masterIndex: 4, want: true},
+		{name: "routed via other link", returnRoute: netutils.RouteInfo{Interface: "eth0", LinkIndex: 2}, masterIndex: 4, want: false},
+		{name: "routed via bridge, peer not enslaved", returnRoute: netutils.RouteInfo{Interface: "cni0", LinkIndex: 4}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := symmetricPath(tt.returnRoute, 12, tt.masterIndex); got != tt.want {
+				t.Errorf("Expected %v. Got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	before := netutils.LinkInfo{RxErrors: 10, TxErrors: 5}
+	if rx, tx := newErrors(before, netutils.LinkInfo{RxErrors: 10, TxErrors: 5}); rx != 0 || tx != 0 {
+		t.Errorf("Expected cumulative errors not to count as new. Got: rx %d, tx %d", rx, tx)
+	}
+	if rx, tx := newErrors(before, netutils.LinkInfo{RxErrors: 12, TxErrors: 5}); rx != 2 || tx != 0 {
+		t.Errorf("Expected 2 new rx errors. Got: rx %d, tx %d", rx, tx)
+	}
+	if rx, tx := newErrors(before, netutils.LinkInfo{}); rx != 0 || tx != 0 {
+		t.Errorf("Expected reset counters not to count as new errors. Got: rx %d, tx %d", rx, tx)
+	}
+}
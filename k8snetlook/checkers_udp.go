@@ -0,0 +1,135 @@
+package k8snetlook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/sarun87/k8snetlook/netutils"
+)
+
+func init() {
+	Register(&checker{
+		name:          "dstpod-udp",
+		description:   "DstPod UDP port reachability check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstPod},
+		run: func(ctx context.Context, env *Env) Result {
+			targets := []Endpoint{{IP: env.Cfg.DstPod.IP, Ports: env.Cfg.DstPod.Ports}}
+			res := RunUDPReachabilityCheck(ctx, env, targets)
+			annotatePolicyDenials(ctx, env, &res, portsWithProtocol(env.Cfg.DstPod.Ports, "UDP"))
+			return res
+		},
+	})
+	Register(&checker{
+		name:          "dstsvc-udp",
+		description:   "DstSvc ClusterIP & Endpoints UDP reachability check",
+		scope:         ScopePod,
+		prerequisites: []Prerequisite{PrereqDstSvc},
+		run: func(ctx context.Context, env *Env) Result {
+			var targets []Endpoint
+			// Headless services have no ClusterIP to probe
+			if env.Cfg.DstSvc.ClusterIP != "None" {
+				targets = append(targets, Endpoint{IP: env.Cfg.DstSvc.ClusterIP, Ports: env.Cfg.DstSvc.Ports})
+			}
+			targets = append(targets, env.Cfg.DstSvc.SvcEndpoints...)
+			res := RunUDPReachabilityCheck(ctx, env, targets)
+			addNotReadyDetails(&res, env.Cfg.DstSvc)
+			return res
+		},
+	})
+}
+
+// RunUDPReachabilityCheck sends a UDP probe to each of the UDP ports of the targets. Fails
+// if any of the ports responds with ICMP port unreachable. Passes if all of the ports
+// reply. Ports that stay silent make the result inconclusive since UDP services
+// aren't required to reply. Non-UDP ports are ignored. Inconclusive if the targets have
+// no UDP ports
+func RunUDPReachabilityCheck(ctx context.Context, env *Env, targets []Endpoint) Result {
+	var res Result
+	totalCount, answeredCount, failedCount := 0, 0, 0
+	for _, target := range targets {
+		for _, port := range target.Ports {
+			if port.Protocol != "UDP" {
+				continue
+			}
+			totalCount++
+			addr := net.JoinHostPort(target.IP, strconv.Itoa(int(port.Port)))
+			payload, isDNS := udpProbePayload(env.Cfg, port)
+			env.Log.Debug("  sending udp probe to %s ........", addr)
+			probe, err := netutils.SendRecvUDPProbe(ctx, target.IP, port.Port, payload)
+			if err != nil {
+				env.Log.Debug("    (Failed) Error probing %s. Error: %v\n", addr, err)
+				res.addDetail("%s: %s: error: %v", port, addr, err)
+				failedCount++
+				if ctx.Err() != nil {
+					res.Err = err
+					return res
+				}
+				continue
+			}
+			env.recordFlow(port.Protocol, probe.LocalAddr, addr, probe.State == netutils.UDPAnswered)
+			switch probe.State {
+			case netutils.UDPAnswered:
+				env.Log.Debug("    (Passed) %s answered in %v\n", addr, probe.RTT)
+				res.addDetail("%s: %s: answered in %v%s", port, addr, probe.RTT, describeUDPReply(probe.Reply, isDNS))
+				answeredCount++
+			case netutils.UDPUnreachable:
+				env.Log.Debug("    (Failed) %s unreachable (ICMP) in %v\n", addr, probe.RTT)
+				res.addDetail("%s: %s: ICMP unreachable in %v. Nothing listening on port or rejected by policy", port, addr, probe.RTT)
+				failedCount++
+			default:
+				env.Log.Debug("    (Inconclusive) no reply from %s after %v\n", addr, probe.RTT)
+				res.addDetail("%s: %s: no reply after %v. Service ignored the payload or packets were dropped", port, addr, probe.RTT)
+			}
+		}
+	}
+	if totalCount == 0 {
+		// Eg: TCP only pods & services
+		res.addDetail("no UDP ports found to probe")
+		res.Inconclusive = true
+		return res
+	}
+	res.Success = answeredCount == totalCount
+	res.Inconclusive = !res.Success && failedCount == 0
+	switch {
+	case res.Success:
+		env.Log.Debug("  (Passed) UDP reachability check to %d ports", totalCount)
+	case res.Inconclusive:
+		env.Log.Debug("  (Inconclusive) UDP reachability check. %d/%d ports did not reply", totalCount-answeredCount, totalCount)
+	default:
+		env.Log.Debug("  (Failed) UDP reachability check for %d/%d ports", failedCount, totalCount)
+	}
+	return res
+}
+
+// udpProbePayload returns the payload to send to a UDP port. A user specified payload
+// takes precedence. A DNS query is sent to port 53 & ports named dns. Returns true if
+// the payload is a DNS query
+func udpProbePayload(cfg *Config, port Port) ([]byte, bool) {
+	if len(cfg.UDPPayload) > 0 {
+		return cfg.UDPPayload, false
+	}
+	if port.Port == 53 || strings.Contains(port.Name, "dns") {
+		if payload, err := netutils.DNSProbePayload(cfg.serviceFQDN("kubernetes", "default")); err == nil {
+			return payload, true
+		}
+	}
+	// Empty datagram. Still triggers ICMP port unreachable if the port is closed
+	return nil, false
+}
+
+// describeUDPReply returns a short description of the reply to be appended to details
+func describeUDPReply(reply []byte, isDNS bool) string {
+	if !isDNS {
+		return fmt.Sprintf(" (%d bytes)", len(reply))
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(reply); err != nil {
+		return fmt.Sprintf(" (%d bytes, not a DNS response)", len(reply))
+	}
+	return fmt.Sprintf(" (DNS %s)", strings.ToUpper(dns.RcodeToString[msg.Rcode]))
+}
@@ -6,45 +6,80 @@ import (
 	log "github.com/sarun87/k8snetlook/logutil"
 )
 
-// PrintReport prints the summary of the checks
-func PrintReport() {
-	log.Info("----------------k8snetlook-----------------")
-	log.Info("")
-	log.Info("----> Host Checks")
-	var hostPassCount, podPassCount int
-	for _, ch := range allChecks.HostChecks {
-		symbol := "fail"
+// Print prints the summary of the checks using logger
+func (r Report) Print(logger log.Logger) {
+	logger.Info("----------------k8snetlook-----------------")
+	logger.Info("")
+	logger.Info("----> Host Checks")
+	var hostPassCount, podPassCount, cancelledCount, inconclusiveCount int
+	for _, ch := range r.HostChecks {
 		if ch.Success {
-			symbol = " ok "
 			hostPassCount++
 		}
-		log.Info(" %s\t%s\n", symbol, ch.Name)
+		if ch.Status == StatusCancelled {
+			cancelledCount++
+		}
+		if ch.Status == StatusInconclusive {
+			inconclusiveCount++
+		}
+		printCheck(logger, ch)
 	}
-	log.Info("")
-	if len(allChecks.PodChecks) > 0 {
-		log.Info("----> Pod Checks (from within SrcPod)")
-		for _, ch := range allChecks.PodChecks {
-			symbol := "fail"
+	logger.Info("")
+	if len(r.PodChecks) > 0 {
+		logger.Info("----> Pod Checks (from within SrcPod)")
+		for _, ch := range r.PodChecks {
 			if ch.Success {
-				symbol = " ok "
 				podPassCount++
 			}
-			log.Info(" %s\t%s\n", symbol, ch.Name)
+			if ch.Status == StatusCancelled {
+				cancelledCount++
+			}
+			if ch.Status == StatusInconclusive {
+				inconclusiveCount++
+			}
+			printCheck(logger, ch)
 		}
 	}
-	log.Info("")
-	log.Info("-------------Summary-------------------")
-	log.Info("")
-	log.Info("  Host Checks: %d/%d\n", hostPassCount, len(allChecks.HostChecks))
-	log.Info("   Pod Checks: %d/%d\n", podPassCount, len(allChecks.PodChecks))
-	log.Info(" Total Checks: %d/%d\n", hostPassCount+podPassCount, len(allChecks.HostChecks)+len(allChecks.PodChecks))
-	log.Info("")
-	log.Info("---------------------------------------")
+	logger.Info("")
+	logger.Info("-------------Summary-------------------")
+	logger.Info("")
+	logger.Info("  Host Checks: %d/%d\n", hostPassCount, len(r.HostChecks))
+	logger.Info("   Pod Checks: %d/%d\n", podPassCount, len(r.PodChecks))
+	logger.Info(" Total Checks: %d/%d\n", hostPassCount+podPassCount, len(r.HostChecks)+len(r.PodChecks))
+	if cancelledCount > 0 {
+		logger.Info("    Cancelled: %d\n", cancelledCount)
+	}
+	if inconclusiveCount > 0 {
+		logger.Info(" Inconclusive: %d\n", inconclusiveCount)
+	}
+	logger.Info("")
+	logger.Info("---------------------------------------")
+}
+
+// printCheck prints the status of the check followed by its details
+func printCheck(logger log.Logger, ch Check) {
+	logger.Info(" %s\t%s\n", statusSymbol(ch), ch.Name)
+	for _, detail := range ch.Details {
+		logger.Info("\t  %s\n", detail)
+	}
+}
+
+// statusSymbol returns the symbol printed in the report for the check
+func statusSymbol(ch Check) string {
+	switch {
+	case ch.Success:
+		return " ok "
+	case ch.Status == StatusCancelled:
+		return "cncl"
+	case ch.Status == StatusInconclusive:
+		return " ?? "
+	}
+	return "fail"
 }
 
-// GetReportJSON returns allChecks object as a JSON string
-func GetReportJSON() string {
-	jsonResult, err := json.Marshal(allChecks)
+// JSON returns the report as a JSON string
+func (r Report) JSON() string {
+	jsonResult, err := json.Marshal(r)
 	if err != nil {
 		return "Unable to return results as JSON string"
 	}
@@ -0,0 +1,99 @@
+package k8snetlook
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// defaultClusterDomain is used if the cluster domain can't be derived from the SrcPod
+const defaultClusterDomain = "cluster.local"
+
+// Resolver implementations of the C libraries used by pods. They expand names using the
+// search list differently
+const (
+	resolverGlibc = "glibc"
+	resolverMusl  = "musl"
+)
+
+// NodeLocal DNSCache runs as the node-local-dns DaemonSet & listens on the nodelocaldns
+// dummy interface of each node
+const (
+	nodeLocalDNSName      = "node-local-dns"
+	nodeLocalDNSInterface = "nodelocaldns"
+	defaultNodeLocalDNSIP = "169.254.20.10"
+)
+
+// DNSConfig describes the resolver configuration of a pod
+type DNSConfig struct {
+	Nameservers []string
+	Search      []string
+	Ndots       int
+	Resolver    string // One of glibc or musl
+}
+
+// readDNSConfig reads the resolver configuration from the resolv.conf at path
+func readDNSConfig(path string) (*DNSConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseDNSConfig(f)
+}
+
+// parseDNSConfig parses resolv.conf formatted resolver configuration
+func parseDNSConfig(r io.Reader) (*DNSConfig, error) {
+	conf, err := dns.ClientConfigFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &DNSConfig{Nameservers: conf.Servers, Search: conf.Search, Ndots: conf.Ndots}, nil
+}
+
+// clusterDomainFromSearch derives the cluster domain from the search list of a pod.
+// kubelet adds <ns>.svc.<domain>, svc.<domain> & <domain> to the search list of pods
+// using the ClusterFirst dns policy. Returns "" if the domain can't be derived
+func clusterDomainFromSearch(search []string) string {
+	for _, domain := range search {
+		domain = strings.TrimSuffix(domain, ".")
+		if strings.HasPrefix(domain, "svc.") && len(domain) > len("svc.") {
+			return strings.TrimPrefix(domain, "svc.")
+		}
+	}
+	return ""
+}
+
+// detectResolver returns musl if the musl dynamic loader is found in the root filesystem
+// at rootPath. Eg: alpine based images. Defaults to glibc
+func detectResolver(rootPath string) string {
+	if matches, _ := filepath.Glob(filepath.Join(rootPath, "lib", "ld-musl-*")); len(matches) > 0 {
+		return resolverMusl
+	}
+	return resolverGlibc
+}
+
+// expandSearchList returns the names, in order, that the resolver queries when looking up
+// name using the search list. Names with a trailing dot are queried as is. glibc tries the
+// name as is first if it has at least ndots dots & after the search list otherwise. musl
+// tries the search list only if the name has fewer than ndots dots
+func expandSearchList(name string, search []string, ndots int, resolver string) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+	absolute := name + "."
+	var searched []string
+	for _, domain := range search {
+		searched = append(searched, name+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+	if strings.Count(name, ".") >= ndots {
+		if resolver == resolverMusl {
+			return []string{absolute}
+		}
+		return append([]string{absolute}, searched...)
+	}
+	return append(searched, absolute)
+}
@@ -0,0 +1,81 @@
+package k8snetlook
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDNSConfig(t *testing.T) {
+	resolvConf := `search default.svc.k8s.example.com svc.k8s.example.com k8s.example.com ec2.internal
+nameserver 10.96.0.10
+options ndots:5
+`
+	conf, err := parseDNSConfig(strings.NewReader(resolvConf))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(conf.Nameservers) != 1 || conf.Nameservers[0] != "10.96.0.10" {
+		t.Errorf("Unexpected nameservers: %v", conf.Nameservers)
+	}
+	if len(conf.Search) != 4 || conf.Ndots != 5 {
+		t.Errorf("Unexpected search list/ndots: %v/%d", conf.Search, conf.Ndots)
+	}
+	if domain := clusterDomainFromSearch(conf.Search); domain != "k8s.example.com" {
+		t.Errorf("Expected cluster domain k8s.example.com. Got: %q", domain)
+	}
+}
+
+func TestClusterDomainFromSearch(t *testing.T) {
+	tests := []struct {
+		search []string
+		domain string
+	}{
+		{[]string{"kube-system.svc.cluster.local.", "svc.cluster.local.", "cluster.local."}, "cluster.local"},
+		{[]string{"ec2.internal"}, ""},
+		{[]string{"svc."}, ""},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		if domain := clusterDomainFromSearch(test.search); domain != test.domain {
+			t.Errorf("Search %v: expected %q, got %q", test.search, test.domain, domain)
+		}
+	}
+}
+
+func TestExpandSearchList(t *testing.T) {
+	search := []string{"default.svc.cluster.local", "svc.cluster.local", "cluster.local"}
+	tests := []struct {
+		name     string
+		ndots    int
+		resolver string
+		expected []string
+	}{
+		{"www.example.com", 5, resolverGlibc, []string{
+			"www.example.com.default.svc.cluster.local.",
+			"www.example.com.svc.cluster.local.",
+			"www.example.com.cluster.local.",
+			"www.example.com.",
+		}},
+		{"www.example.com", 2, resolverGlibc, []string{
+			"www.example.com.",
+			"www.example.com.default.svc.cluster.local.",
+			"www.example.com.svc.cluster.local.",
+			"www.example.com.cluster.local.",
+		}},
+		{"www.example.com", 2, resolverMusl, []string{"www.example.com."}},
+		{"kubernetes", 1, resolverMusl, []string{
+			"kubernetes.default.svc.cluster.local.",
+			"kubernetes.svc.cluster.local.",
+			"kubernetes.cluster.local.",
+			"kubernetes.",
+		}},
+		{"www.example.com.", 5, resolverGlibc, []string{"www.example.com."}},
+	}
+	for _, test := range tests {
+		names := expandSearchList(test.name, search, test.ndots, test.resolver)
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s ndots:%d %s: expected %v, got %v", test.name, test.ndots, test.resolver, test.expected, names)
+		}
+	}
+}
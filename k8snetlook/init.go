@@ -2,17 +2,33 @@ package k8snetlook
 
 import (
 	"context"
-	"os"
+	"fmt"
 	"strings"
+	"time"
 
-	"github.com/docker/docker/client"
 	log "github.com/sarun87/k8snetlook/logutil"
 	"github.com/sarun87/k8snetlook/netutils"
 	"github.com/vishvananda/netns"
+	"k8s.io/client-go/kubernetes"
 )
 
 const (
 	defaultInternetEgressTestIP = "8.8.8.8"
+
+	// DefaultCheckTimeout is the time a single check is allowed to run for
+	// unless overridden using Config.Timeout or Config.CheckTimeouts
+	DefaultCheckTimeout = 30 * time.Second
+	// defaultKubeClientTimeout is the timeout of requests to the k8s api server
+	defaultKubeClientTimeout = 4 * time.Second
+)
+
+// Check status values
+const (
+	StatusPassed    = "passed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	// StatusInconclusive is reported when the outcome could not be determined
+	StatusInconclusive = "inconclusive"
 )
 
 // Pod struct specifies properties required for Pod network debugging
@@ -20,6 +36,10 @@ type Pod struct {
 	Name      string
 	Namespace string
 	IP        string
+	NodeName  string // Node the pod is scheduled on
+	Labels    map[string]string
+	DNSConfig *DNSConfig     // Read from the pod's /etc/resolv.conf. nil if unknown
+	Ports     []Port         // Ports declared by the pod's containers
 	NsHandle  netns.NsHandle // Initializes this with an open FD to the netns file /proc/<pid>/ns/net
 }
 
@@ -27,115 +47,370 @@ type Pod struct {
 type Service struct {
 	Name         string
 	Namespace    string
-	ClusterIP    Endpoint
-	SvcEndpoints []Endpoint
+	Type         string     // One of ClusterIP, NodePort, LoadBalancer, ExternalName
+	ClusterIP    string     // "None" for headless services
+	Ports        []Port     // All of the ports exposed by the service
+	SvcEndpoints []Endpoint // Endpoints that are ready to receive traffic
+	// NotReadyEndpoints are reported separately & not used for connectivity checks
+	NotReadyEndpoints []Endpoint
+	// PublishNotReadyAddresses is set if DNS records are published for not ready endpoints
+	PublishNotReadyAddresses bool
+	// ExternalName is the DNS name the service is an alias of. Set for ExternalName services
+	ExternalName string
+	// ExternalTrafficPolicy is one of Cluster or Local. Set for NodePort & LoadBalancer services
+	ExternalTrafficPolicy string
+	// LoadBalancerIngress lists the IPs or hostnames assigned by the load balancer
+	LoadBalancerIngress []string
+	// ClusterIPs holds the ClusterIP of each IP family of dual-stack services. The
+	// first one is ClusterIP
+	ClusterIPs []string
 }
 
 // Endpoint struct specifies properties that an Endpoint represents
 type Endpoint struct {
-	IP   string
-	Port int32
+	IP       string
+	Ports    []Port // All of the named ports of the endpoint
+	NodeName string // Node hosting the endpoint, if known
+	// Hostname is set for pods with a hostname & subdomain matching a headless service.
+	// Eg: StatefulSet pods. Such pods get a <hostname>.<svc>.<ns>.svc.<domain> DNS record
+	Hostname string
+	// Ready, Serving & Terminating are the conditions of the endpoint. Endpoints read
+	// from the legacy Endpoints api are never terminating
+	Ready       bool
+	Serving     bool
+	Terminating bool
+	Zone        string   // Topology zone of the endpoint, if known
+	ZoneHints   []string // Zones the endpoint should be consumed from with topology aware routing
+}
+
+// conditions returns a description of the conditions of the endpoint
+func (e Endpoint) conditions() string {
+	conditions := []string{"not ready"}
+	if e.Ready {
+		conditions[0] = "ready"
+	}
+	if e.Serving {
+		conditions = append(conditions, "serving")
+	}
+	if e.Terminating {
+		conditions = append(conditions, "terminating")
+	}
+	if e.Zone != "" {
+		conditions = append(conditions, "zone "+e.Zone)
+	}
+	if len(e.ZoneHints) > 0 {
+		conditions = append(conditions, "hints "+strings.Join(e.ZoneHints, ","))
+	}
+	return strings.Join(conditions, ", ")
+}
+
+// lookupPort returns the port of the endpoint named name. Falls back to the first
+// port if none of the ports is named name. Returns false if the endpoint has no ports
+func (e Endpoint) lookupPort(name string) (Port, bool) {
+	for _, port := range e.Ports {
+		if port.Name == name {
+			return port, true
+		}
+	}
+	if len(e.Ports) == 0 {
+		return Port{}, false
+	}
+	return e.Ports[0], true
+}
+
+// Port struct specifies a named port & its protocol
+type Port struct {
+	Name     string
+	Port     int32
+	Protocol string // One of TCP, UDP, SCTP
+	NodePort int32  // Port exposed on every node. Set for NodePort & LoadBalancer services
+	// TargetPort is the number or name of the endpoint port that traffic to a service
+	// port is sent to. Set for service ports
+	TargetPort string
+}
+
+// Node struct specifies properties of a K8s node used to reach NodePorts
+type Node struct {
+	Name string
+	IP   string // InternalIP of the node. ExternalIP if the node has no InternalIP
+}
+
+func (p Port) String() string {
+	if p.Name == "" {
+		return fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+	}
+	return fmt.Sprintf("%s(%d/%s)", p.Name, p.Port, p.Protocol)
 }
 
 // Config struct represents the properties required by k8snetlook to run checks
 // most properties are populated from user input
 type Config struct {
-	SrcPod         Pod
-	DstPod         Pod
-	DstSvc         Service
-	ExternalIP     string
-	KubeconfigPath string
+	SrcPod     Pod
+	DstPod     Pod
+	DstSvc     Service
+	ExternalIP string
+	// ExternalHost is a name resolved from the SrcPod using its search list
+	ExternalHost string
+	// Checks lists the names of checks to run. All checks are run if empty
+	Checks []string
+	// SkipChecks lists the names of checks to skip
+	SkipChecks []string
+	// Timeout is the time each check is allowed to run for. Also used as the
+	// timeout for requests to the k8s api server
+	Timeout time.Duration
+	// CheckTimeouts overrides Timeout for specific checks keyed by check name
+	CheckTimeouts map[string]time.Duration
+	// DNSRetries is the number of times DNS queries are retried after a timeout.
+	// netutils.DefaultDNSRetries is used if 0. Set to a negative value to disable retries
+	DNSRetries int
+	// UDPPayload is sent by UDP checks. If empty, a payload is chosen based on the
+	// port. Eg: a DNS query for port 53
+	UDPPayload []byte
+
+	// ClusterDomain is the DNS domain of the cluster. Derived from the search list of
+	// the SrcPod if not set
+	ClusterDomain string
 
 	KubeAPIService Endpoint
 	KubeDNSService Endpoint
-	HostGatewayIP  string
+	// NodeLocalDNS describes the NodeLocal DNSCache deployment found on the host
+	NodeLocalDNS  NodeLocalDNS
+	HostGatewayIP string
+	// Nodes lists the nodes of the cluster. Fetched only if DstSvc exposes NodePorts
+	Nodes []Node
+	// LocalNodeName is the name of the node k8snetlook is run on, if known
+	LocalNodeName string
+}
+
+// NodeLocalDNS describes the NodeLocal DNSCache deployment of the cluster
+type NodeLocalDNS struct {
+	DaemonSet string   // Name of the node-local-dns DaemonSet in kube-system, if found
+	Interface bool     // nodelocaldns interface exists on the host
+	IPs       []string // IPs the local cache listens on
+}
+
+// enabled checks if NodeLocal DNSCache was found in the cluster or on the host
+func (n NodeLocalDNS) enabled() bool {
+	return n.DaemonSet != "" || n.Interface
 }
 
 // Check describes the reporting structure for a network check
 type Check struct {
-	Name     string `json:"name"`
-	Success  bool   `json:"success"`
-	ErrorMsg error  `json:"error_msg"`
+	Name     string   `json:"name"`
+	Success  bool     `json:"success"`
+	Status   string   `json:"status"` // One of StatusPassed, StatusFailed, StatusCancelled, StatusInconclusive
+	ErrorMsg error    `json:"error_msg"`
+	Details  []string `json:"details,omitempty"`
 }
 
-// Checker stores check names and results for all of the checks
-type Checker struct {
+// Report stores check names and results for all of the checks
+type Report struct {
 	PodChecks  []Check `json:"pod_checks,omitempty"`
 	HostChecks []Check `json:"host_checks"`
 }
 
-// allChecks holds information about the checks being run by k8snetlook
-var allChecks Checker
+// Session holds the state required to diagnose a cluster. Sessions do not
+// share state and multiple sessions can be run concurrently
+type Session struct {
+	cfg            Config
+	client         kubernetes.Interface
+	log            log.Logger
+	resolver       NetnsResolver
+	procfs         procfsResolver // Used to access the SrcPod netns & files using its pid
+	kubeconfigPath string
+	flows          *flowRecorder // Flows opened by the probes of host & pod checks
+}
 
-// Cfg is an instance of Config struct
-var Cfg Config
+// Option configures a Session
+type Option func(*Session)
 
-// Init initializes k8snetlook
-func Init(kubeconfigPath string) error {
-	if err := initKubernetesClient(kubeconfigPath); err != nil {
-		return err
+// WithKubeconfig specifies the kubeconfig used to create a kubernetes client when
+// not running within a pod
+func WithKubeconfig(path string) Option {
+	return func(s *Session) {
+		s.kubeconfigPath = path
 	}
-	return initK8sInfo()
+}
+
+// WithClientset specifies the kubernetes client to use instead of creating one
+func WithClientset(client kubernetes.Interface) Option {
+	return func(s *Session) {
+		s.client = client
+	}
+}
+
+// WithLogger specifies the logger used by the session
+func WithLogger(logger log.Logger) Option {
+	return func(s *Session) {
+		s.log = logger
+	}
+}
+
+// WithNetnsResolver specifies the resolver used to look up the process of the SrcPod
+func WithNetnsResolver(resolver NetnsResolver) Option {
+	return func(s *Session) {
+		s.resolver = resolver
+	}
+}
+
+// NewSession creates a session & initializes information related to pods,
+// services in cfg by querying the k8s api. Close must be called once done
+func NewSession(ctx context.Context, cfg Config, opts ...Option) (*Session, error) {
+	s := &Session{cfg: cfg, procfs: procfsResolver{procRoot: defaultProcRoot}, flows: &flowRecorder{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.log == nil {
+		s.log = log.New(log.INFO)
+	}
+	if s.resolver == nil {
+		s.resolver = NewNetnsResolver("")
+	}
+	if s.client == nil {
+		timeout := defaultKubeClientTimeout
+		if cfg.Timeout > 0 {
+			timeout = cfg.Timeout
+		}
+		client, err := newKubernetesClient(s.log, s.kubeconfigPath, timeout)
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	if err := s.initK8sInfo(ctx); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newEnv returns an environment for running checkers in the specified scope
+func (s *Session) newEnv(scope Scope) *Env {
+	return &Env{Cfg: &s.cfg, Client: s.client, Log: s.log, Scope: scope, HostNsHandle: netns.None(), flows: s.flows}
 }
 
 // initK8sInfo initializes information related to pods, services by querying k8s api
-func initK8sInfo() error {
+func (s *Session) initK8sInfo(ctx context.Context) error {
 	var err error
+	env := s.newEnv(ScopeHost)
 	// If we aren't able to talk to the k8sapiserver endpoint via ip specified by kubeconfig,
 	// return. Do not execute further
-	if Cfg.KubeAPIService, err = getServiceClusterIP("default", "kubernetes"); err != nil {
+	if s.cfg.KubeAPIService, err = env.getServiceClusterIP(ctx, "default", "kubernetes"); err != nil {
 		return err
 	}
-	Cfg.HostGatewayIP, _ = netutils.GetHostGatewayIP()
-	Cfg.KubeDNSService, _ = getServiceClusterIP("kube-system", "kube-dns")
-	Cfg.SrcPod.NsHandle = netns.NsHandle(-1)
-	if Cfg.SrcPod.Name != "" && Cfg.SrcPod.Namespace != "" {
-		Cfg.SrcPod.IP = getPodIPFromName(Cfg.SrcPod.Namespace, Cfg.SrcPod.Name)
-		Cfg.SrcPod.NsHandle = getPodNetnsHandle(Cfg.SrcPod.Namespace, Cfg.SrcPod.Name)
+	s.cfg.HostGatewayIP, _ = netutils.GetHostGatewayIP(s.log)
+	s.cfg.KubeDNSService, _ = env.getServiceClusterIP(ctx, "kube-system", "kube-dns")
+	s.cfg.NodeLocalDNS = env.detectNodeLocalDNS(ctx)
+	s.cfg.SrcPod.NsHandle = netns.None()
+	if s.cfg.SrcPod.Name != "" && s.cfg.SrcPod.Namespace != "" {
+		pod, err := env.getPod(ctx, s.cfg.SrcPod.Namespace, s.cfg.SrcPod.Name)
+		if err != nil {
+			return err
+		}
+		s.cfg.SrcPod.IP = pod.Status.PodIP
+		s.cfg.SrcPod.NodeName = pod.Spec.NodeName
+		s.cfg.SrcPod.Labels = pod.Labels
+		pid, err := s.resolver.GetPodPid(ctx, pod)
+		if err != nil {
+			return fmt.Errorf("unable to fetch pid of pod %s: %v", s.cfg.SrcPod.Name, err)
+		}
+		if s.cfg.SrcPod.NsHandle, err = netns.GetFromPath(s.procfs.netnsPath(pid)); err != nil {
+			return fmt.Errorf("unable to fetch netns handle for pod %s: %v", s.cfg.SrcPod.Name, err)
+		}
+		// DNS checks fall back to kube-dns & the default domain if resolv.conf can't be read
+		if s.cfg.SrcPod.DNSConfig, err = readDNSConfig(s.procfs.resolvConfPath(pid)); err != nil {
+			s.log.Debug("Unable to read resolv.conf of pod %s. Error: %v", s.cfg.SrcPod.Name, err)
+		} else {
+			s.cfg.SrcPod.DNSConfig.Resolver = detectResolver(s.procfs.rootPath(pid))
+		}
 	}
-	Cfg.DstPod.NsHandle = netns.NsHandle(-1)
-	if Cfg.DstPod.Name != "" && Cfg.DstPod.Namespace != "" {
-		Cfg.DstPod.IP = getPodIPFromName(Cfg.DstPod.Namespace, Cfg.DstPod.Name)
+	if s.cfg.ClusterDomain == "" && s.cfg.SrcPod.DNSConfig != nil {
+		s.cfg.ClusterDomain = clusterDomainFromSearch(s.cfg.SrcPod.DNSConfig.Search)
 	}
-	if Cfg.DstSvc.Name != "" && Cfg.DstSvc.Namespace != "" {
-		Cfg.DstSvc.ClusterIP, _ = getServiceClusterIP(Cfg.DstSvc.Namespace, Cfg.DstSvc.Name)
-		Cfg.DstSvc.SvcEndpoints = getEndpointsFromService(Cfg.DstSvc.Namespace, Cfg.DstSvc.Name)
+	if s.cfg.ClusterDomain == "" {
+		s.cfg.ClusterDomain = defaultClusterDomain
+	}
+	s.cfg.DstPod.NsHandle = netns.None()
+	if s.cfg.DstPod.Name != "" && s.cfg.DstPod.Namespace != "" {
+		if pod, err := env.getPod(ctx, s.cfg.DstPod.Namespace, s.cfg.DstPod.Name); err == nil {
+			s.cfg.DstPod.IP = pod.Status.PodIP
+			s.cfg.DstPod.NodeName = pod.Spec.NodeName
+			s.cfg.DstPod.Labels = pod.Labels
+			s.cfg.DstPod.Ports = getPodPorts(pod)
+		}
+	}
+	if s.cfg.DstSvc.Name != "" && s.cfg.DstSvc.Namespace != "" {
+		if service, err := env.getService(ctx, s.cfg.DstSvc.Namespace, s.cfg.DstSvc.Name); err == nil {
+			s.cfg.DstSvc.Type = string(service.Spec.Type)
+			s.cfg.DstSvc.ClusterIP = service.Spec.ClusterIP
+			s.cfg.DstSvc.ClusterIPs = service.Spec.ClusterIPs
+			s.cfg.DstSvc.Ports = getServicePorts(service)
+			s.cfg.DstSvc.ExternalName = service.Spec.ExternalName
+			s.cfg.DstSvc.PublishNotReadyAddresses = service.Spec.PublishNotReadyAddresses
+			s.cfg.DstSvc.ExternalTrafficPolicy = string(service.Spec.ExternalTrafficPolicy)
+			s.cfg.DstSvc.LoadBalancerIngress = getLoadBalancerIngress(service)
+		}
+		s.cfg.DstSvc.SvcEndpoints, s.cfg.DstSvc.NotReadyEndpoints = splitEndpoints(
+			env.getEndpointsFromService(ctx, s.cfg.DstSvc.Namespace, s.cfg.DstSvc.Name))
+		if s.cfg.DstSvc.hasNodePorts() {
+			// Nodes are required to probe the NodePort on every node
+			s.cfg.Nodes, _ = env.getNodes(ctx)
+			s.cfg.LocalNodeName = localNodeName(s.cfg.Nodes, s.cfg.SrcPod.NodeName)
+		}
 	}
 	return nil
 }
 
-func getPodNetnsHandle(namespace string, podName string) netns.NsHandle {
-	containerID := getContainerIDFromPod(namespace, podName)
-	if containerID == "" {
-		log.Error("Unable to fetch container id for pod %s. Exiting..\n", podName)
-		Cleanup()
-		os.Exit(1)
+// detectNodeLocalDNS looks for the node-local-dns DaemonSet & the nodelocaldns interface
+// on the host. The default link-local IP is assumed if only the DaemonSet is found
+func (e *Env) detectNodeLocalDNS(ctx context.Context) NodeLocalDNS {
+	ret := NodeLocalDNS{DaemonSet: e.getNodeLocalDNSDaemonSet(ctx)}
+	if ips, err := netutils.GetLinkIPs(nodeLocalDNSInterface); err == nil {
+		ret.Interface = true
+		ret.IPs = ips
+	} else {
+		e.Log.Debug("Unable to read %s interface. Error: %v", nodeLocalDNSInterface, err)
+	}
+	if ret.DaemonSet != "" && len(ret.IPs) == 0 {
+		ret.IPs = []string{defaultNodeLocalDNSIP}
+	}
+	return ret
+}
+
+// clusterIPs returns the ClusterIP of each IP family of the service. Services read from
+// older API servers only have ClusterIP set. Empty for headless services
+func (svc *Service) clusterIPs() []string {
+	if svc.ClusterIP == "" || svc.ClusterIP == "None" {
+		return nil
 	}
-	containerID = strings.TrimPrefix(containerID, "docker://")
-	log.Debug("ContainerID:%s\n", containerID)
-	cli, err := client.NewClientWithOpts(client.FromEnv,client.WithAPIVersionNegotiation())
-	if err != nil {
-		log.Error("Unable to create docker client: %v", err)
-		os.Exit(1)
+	if len(svc.ClusterIPs) == 0 {
+		return []string{svc.ClusterIP}
 	}
-	containerJSON, err := cli.ContainerInspect(context.Background(), containerID)
-	if err != nil {
-		log.Error("Unable to inspect container: %v", err)
-		os.Exit(1)
+	return svc.ClusterIPs
+}
+
+// hasNodePorts checks if any of the service ports is exposed on the nodes
+func (svc *Service) hasNodePorts() bool {
+	for _, port := range svc.Ports {
+		if port.NodePort != 0 {
+			return true
+		}
 	}
-	log.Debug("Pid of container: %d\n", containerJSON.State.Pid)
-	nshandle, err := netns.GetFromPid(containerJSON.State.Pid)
-	if err != nil {
-		log.Error("Unable to fetch netns handle for pod %s. Error: %v Exiting..\n", podName, err)
-		Cleanup()
-		os.Exit(1)
+	return false
+}
+
+// Run runs host checks & if SrcPod is specified, pod checks. Returns the report
+func (s *Session) Run(ctx context.Context) Report {
+	report := Report{HostChecks: s.RunHostChecks(ctx)}
+	if s.cfg.SrcPod.NsHandle.IsOpen() {
+		report.PodChecks = s.RunPodChecks(ctx)
 	}
-	return nshandle
+	return report
 }
 
-// Cleanup closes all of the open network namespaces handles
-func Cleanup() {
-	if Cfg.SrcPod.NsHandle.IsOpen() {
-		Cfg.SrcPod.NsHandle.Close()
+// Close closes all of the open network namespaces handles
+func (s *Session) Close() {
+	if s.cfg.SrcPod.NsHandle.IsOpen() {
+		s.cfg.SrcPod.NsHandle.Close()
 	}
 }
@@ -0,0 +1,392 @@
+package k8snetlook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeResolver returns the pid of the current process for every pod
+type fakeResolver struct {
+	pods []string
+}
+
+func (f *fakeResolver) GetPodPid(ctx context.Context, pod *corev1.Pod) (int, error) {
+	f.pods = append(f.pods, pod.Namespace+"/"+pod.Name)
+	return os.Getpid(), nil
+}
+
+// withProcRoot makes the session access the processes of pods under root instead of /proc
+func withProcRoot(root string) Option {
+	return func(s *Session) {
+		s.procfs = procfsResolver{procRoot: root}
+	}
+}
+
+// newFakeProcRoot returns a procfs root in which the current process has the netns of the
+// current process & resolvConf as its resolv.conf. resolv.conf is missing if resolvConf is empty
+func newFakeProcRoot(t *testing.T, resolvConf string) string {
+	root := t.TempDir()
+	pidDir := filepath.Join(root, strconv.Itoa(os.Getpid()))
+	if err := os.MkdirAll(filepath.Join(pidDir, "ns"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(fmt.Sprintf("/proc/%d/ns/net", os.Getpid()), filepath.Join(pidDir, "ns", "net")); err != nil {
+		t.Fatal(err)
+	}
+	if resolvConf == "" {
+		return root
+	}
+	if err := os.MkdirAll(filepath.Join(pidDir, "root", "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(pidDir, "root", "etc", "resolv.conf"), []byte(resolvConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func newFakeService(namespace, name, clusterIP string, port int32) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: clusterIP,
+			Ports:     []corev1.ServicePort{{Port: port}},
+		},
+	}
+}
+
+// newFakeEndpoints returns the Endpoints of a service with addresses listening on port
+func newFakeEndpoints(namespace, name string, port int32, addresses ...corev1.EndpointAddress) *corev1.Endpoints {
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: addresses,
+			Ports:     []corev1.EndpointPort{{Port: port}},
+		}},
+	}
+}
+
+// TestNewSession checks that the config is populated from the k8s api. The checks
+// using the config are tested separately
+func TestNewSession(t *testing.T) {
+	kubeAPI := newFakeService("default", "kubernetes", "10.96.0.1", 443)
+	dstSvc := Config{DstSvc: Service{Name: "web", Namespace: "default"}}
+
+	lbSvc := newFakeService("default", "web", "10.96.0.20", 80)
+	lbSvc.Spec.Type = corev1.ServiceTypeLoadBalancer
+	lbSvc.Spec.Ports[0].NodePort = 30080
+	lbSvc.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+	lbSvc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}, {Hostname: "lb.example.com"}}
+	nodeName := "node-a"
+
+	multiPortSvc := newFakeService("default", "web", "10.96.0.20", 80)
+	multiPortSvc.Spec.Ports = []corev1.ServicePort{
+		{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+		{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP, TargetPort: intstr.FromString("dns")},
+	}
+
+	portlessSvc := newFakeService("default", "web", "None", 0)
+	portlessSvc.Spec.Ports = nil
+
+	boolPtr := func(b bool) *bool { return &b }
+	strPtr := func(s string) *string { return &s }
+	slicePort := int32(8080)
+	newSlice := func(name string, addressType discoveryv1.AddressType, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+		return &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      name,
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+			},
+			AddressType: addressType,
+			Ports:       []discoveryv1.EndpointPort{{Name: strPtr("http"), Port: &slicePort}},
+			Endpoints:   endpoints,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		objects    []runtime.Object
+		cfg        Config
+		resolvConf string // resolv.conf of the SrcPod
+		wantErr    bool
+		check      func(t *testing.T, cfg Config, resolver *fakeResolver)
+	}{
+		{
+			name:    "kubernetes service missing",
+			wantErr: true,
+		},
+		{
+			name: "SrcPod & cluster services",
+			objects: []runtime.Object{
+				kubeAPI,
+				newFakeService("kube-system", "kube-dns", "10.96.0.10", 53),
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "src"},
+					Status:     corev1.PodStatus{PodIP: "10.244.0.5"},
+				},
+			},
+			cfg: Config{SrcPod: Pod{Name: "src", Namespace: "default"}},
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				if cfg.KubeAPIService.IP != "10.96.0.1" || len(cfg.KubeAPIService.Ports) != 1 || cfg.KubeAPIService.Ports[0].Port != 443 {
+					t.Errorf("Unexpected kube api service: %+v", cfg.KubeAPIService)
+				}
+				if cfg.KubeDNSService.IP != "10.96.0.10" || cfg.SrcPod.IP != "10.244.0.5" {
+					t.Errorf("Unexpected kube dns service %+v or SrcPod IP %s", cfg.KubeDNSService, cfg.SrcPod.IP)
+				}
+				if len(resolver.pods) != 1 || resolver.pods[0] != "default/src" || !cfg.SrcPod.NsHandle.IsOpen() {
+					t.Errorf("Expected netns of default/src to be opened. Resolved: %v", resolver.pods)
+				}
+				if cfg.SrcPod.DNSConfig != nil || cfg.ClusterDomain != defaultClusterDomain {
+					t.Errorf("Expected default cluster domain if pod resolv.conf is unknown. Got: %q", cfg.ClusterDomain)
+				}
+			},
+		},
+		{
+			name: "SrcPod resolv.conf",
+			objects: []runtime.Object{
+				kubeAPI,
+				&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "src"}},
+			},
+			cfg:        Config{SrcPod: Pod{Name: "src", Namespace: "default"}},
+			resolvConf: "nameserver 10.96.0.10\nsearch default.svc.k8s.example svc.k8s.example k8s.example\noptions ndots:5\n",
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				dnsConfig := cfg.SrcPod.DNSConfig
+				if dnsConfig == nil || len(dnsConfig.Nameservers) != 1 || dnsConfig.Ndots != 5 {
+					t.Fatalf("Expected resolv.conf of the pod process to be read. Got: %+v", dnsConfig)
+				}
+				if cfg.ClusterDomain != "k8s.example" {
+					t.Errorf("Expected cluster domain from the search list. Got: %q", cfg.ClusterDomain)
+				}
+			},
+		},
+		{
+			name: "node-local-dns",
+			objects: []runtime.Object{
+				kubeAPI,
+				&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "node-local-dns",
+					Labels: map[string]string{"k8s-app": "node-local-dns"}}},
+			},
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				nodeLocal := cfg.NodeLocalDNS
+				if !nodeLocal.enabled() || nodeLocal.DaemonSet != "node-local-dns" || len(nodeLocal.IPs) == 0 {
+					t.Fatalf("Expected node-local-dns to be detected. Got: %+v", nodeLocal)
+				}
+				if !nodeLocal.Interface && nodeLocal.IPs[0] != defaultNodeLocalDNSIP {
+					t.Errorf("Expected default cache IP without the %s interface. Got: %v", nodeLocalDNSInterface, nodeLocal.IPs)
+				}
+			},
+		},
+		{
+			name: "LoadBalancer service & nodes",
+			objects: []runtime.Object{
+				kubeAPI,
+				lbSvc,
+				newFakeEndpoints("default", "web", 8080, corev1.EndpointAddress{IP: "10.244.1.5", NodeName: &nodeName}),
+				&corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+					Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+						{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+						{Type: corev1.NodeInternalIP, Address: "192.0.2.1"},
+					}},
+				},
+			},
+			cfg: dstSvc,
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				svc := cfg.DstSvc
+				if svc.Type != "LoadBalancer" || svc.ExternalTrafficPolicy != "Local" {
+					t.Errorf("Unexpected service type/policy: %s/%s", svc.Type, svc.ExternalTrafficPolicy)
+				}
+				if len(svc.Ports) != 1 || svc.Ports[0].NodePort != 30080 {
+					t.Errorf("Unexpected service ports: %+v", svc.Ports)
+				}
+				if len(svc.LoadBalancerIngress) != 2 || svc.LoadBalancerIngress[1] != "lb.example.com" {
+					t.Errorf("Unexpected load balancer ingress: %v", svc.LoadBalancerIngress)
+				}
+				if len(svc.SvcEndpoints) != 1 || svc.SvcEndpoints[0].NodeName != "node-a" {
+					t.Errorf("Unexpected endpoints: %+v", svc.SvcEndpoints)
+				}
+				if len(cfg.Nodes) != 1 || cfg.Nodes[0].IP != "192.0.2.1" {
+					t.Errorf("Expected InternalIP of node to be used. Got: %+v", cfg.Nodes)
+				}
+			},
+		},
+		{
+			name: "multi port service",
+			objects: []runtime.Object{
+				kubeAPI,
+				multiPortSvc,
+				&corev1.Endpoints{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+					Subsets: []corev1.EndpointSubset{
+						{
+							Addresses: []corev1.EndpointAddress{{IP: "10.244.1.5"}, {IP: "10.244.1.6"}},
+							Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}},
+						},
+						{
+							Addresses: []corev1.EndpointAddress{{IP: "10.244.1.5"}},
+							Ports:     []corev1.EndpointPort{{Name: "dns", Port: 5353, Protocol: corev1.ProtocolUDP}},
+						},
+					},
+				},
+			},
+			cfg: dstSvc,
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				ports := cfg.DstSvc.Ports
+				if len(ports) != 2 || ports[0].TargetPort != "8080" || ports[1].TargetPort != "dns" || ports[1].Protocol != "UDP" {
+					t.Errorf("Unexpected service ports: %+v", ports)
+				}
+				endpoints := cfg.DstSvc.SvcEndpoints
+				if len(endpoints) != 2 || endpoints[0].IP != "10.244.1.5" || len(endpoints[0].Ports) != 2 || len(endpoints[1].Ports) != 1 {
+					t.Errorf("Expected ports of an address in multiple subsets to be merged. Got: %+v", endpoints)
+				}
+			},
+		},
+		{
+			name:    "headless service without ports",
+			objects: []runtime.Object{kubeAPI, portlessSvc},
+			cfg:     dstSvc,
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				if cfg.DstSvc.ClusterIP != "None" || len(cfg.DstSvc.Ports) != 0 {
+					t.Errorf("Unexpected service: %+v", cfg.DstSvc)
+				}
+			},
+		},
+		{
+			name: "EndpointSlices",
+			objects: []runtime.Object{
+				kubeAPI,
+				newFakeService("default", "web", "10.96.0.20", 80),
+				newSlice("web-v4", discoveryv1.AddressTypeIPv4,
+					discoveryv1.Endpoint{
+						Addresses: []string{"10.244.1.5"},
+						Zone:      strPtr("zone-a"),
+						Hints:     &discoveryv1.EndpointHints{ForZones: []discoveryv1.ForZone{{Name: "zone-a"}}},
+					},
+					discoveryv1.Endpoint{
+						Addresses:  []string{"10.244.1.6"},
+						Conditions: discoveryv1.EndpointConditions{Ready: boolPtr(false), Serving: boolPtr(true), Terminating: boolPtr(true)},
+					},
+				),
+				newSlice("web-v6", discoveryv1.AddressTypeIPv6,
+					discoveryv1.Endpoint{Addresses: []string{"fd00::5"}, Conditions: discoveryv1.EndpointConditions{Ready: boolPtr(true)}},
+				),
+				// Endpoints are ignored when EndpointSlices are found
+				newFakeEndpoints("default", "web", 8080, corev1.EndpointAddress{IP: "10.244.9.9"}),
+			},
+			cfg: dstSvc,
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				ready, notReady := cfg.DstSvc.SvcEndpoints, cfg.DstSvc.NotReadyEndpoints
+				if len(ready) != 2 || ready[0].IP != "10.244.1.5" || ready[1].IP != "fd00::5" {
+					t.Fatalf("Unexpected ready endpoints: %+v", ready)
+				}
+				if !ready[0].Serving || ready[0].Zone != "zone-a" || len(ready[0].ZoneHints) != 1 || len(ready[0].Ports) != 1 {
+					t.Errorf("Unexpected endpoint: %+v", ready[0])
+				}
+				if len(notReady) != 1 || notReady[0].IP != "10.244.1.6" || !notReady[0].Serving || !notReady[0].Terminating {
+					t.Errorf("Unexpected not ready endpoints: %+v", notReady)
+				}
+			},
+		},
+		{
+			name: "address in multiple EndpointSlices",
+			objects: []runtime.Object{
+				kubeAPI,
+				newFakeService("default", "web", "10.96.0.20", 80),
+				newSlice("web-a", discoveryv1.AddressTypeIPv4,
+					discoveryv1.Endpoint{Addresses: []string{"10.244.1.5"}, Conditions: discoveryv1.EndpointConditions{Ready: boolPtr(false)}},
+					discoveryv1.Endpoint{Addresses: []string{"10.244.1.6"}},
+				),
+				newSlice("web-b", discoveryv1.AddressTypeIPv4,
+					discoveryv1.Endpoint{Addresses: []string{"10.244.1.5"}, Conditions: discoveryv1.EndpointConditions{Ready: boolPtr(true)}},
+					discoveryv1.Endpoint{Addresses: []string{"10.244.1.6"}},
+				),
+			},
+			cfg: dstSvc,
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				ready, notReady := cfg.DstSvc.SvcEndpoints, cfg.DstSvc.NotReadyEndpoints
+				if len(ready) != 2 || len(notReady) != 0 {
+					t.Fatalf("Expected an address ready in any slice to be ready. Ready: %+v Not ready: %+v", ready, notReady)
+				}
+				for _, ep := range ready {
+					if len(ep.Ports) != 1 {
+						t.Errorf("Expected ports listed in multiple slices to be de-duplicated. Got: %+v", ep)
+					}
+				}
+			},
+		},
+		{
+			name: "dual-stack service",
+			objects: []runtime.Object{
+				kubeAPI,
+				func() *corev1.Service {
+					svc := newFakeService("default", "web", "10.96.0.20", 80)
+					svc.Spec.ClusterIPs = []string{"10.96.0.20", "fd00:96::20"}
+					return svc
+				}(),
+			},
+			cfg: dstSvc,
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				if ips := cfg.DstSvc.clusterIPs(); len(ips) != 2 || ips[1] != "fd00:96::20" {
+					t.Errorf("Unexpected ClusterIPs: %v", ips)
+				}
+			},
+		},
+		{
+			name: "not ready Endpoints",
+			objects: []runtime.Object{
+				kubeAPI,
+				newFakeService("default", "web", "10.96.0.20", 80),
+				&corev1.Endpoints{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+					Subsets: []corev1.EndpointSubset{{
+						Addresses:         []corev1.EndpointAddress{{IP: "10.244.1.5", Hostname: "web-0"}},
+						NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.244.1.6", Hostname: "web-1"}},
+						Ports:             []corev1.EndpointPort{{Port: 8080}},
+					}},
+				},
+			},
+			cfg: dstSvc,
+			check: func(t *testing.T, cfg Config, resolver *fakeResolver) {
+				ready, notReady := cfg.DstSvc.SvcEndpoints, cfg.DstSvc.NotReadyEndpoints
+				if len(ready) != 1 || ready[0].IP != "10.244.1.5" || ready[0].Hostname != "web-0" {
+					t.Errorf("Unexpected ready endpoints: %+v", ready)
+				}
+				if len(notReady) != 1 || notReady[0].IP != "10.244.1.6" {
+					t.Errorf("Unexpected not ready endpoints: %+v", notReady)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &fakeResolver{}
+			s, err := NewSession(context.Background(), tt.cfg, WithClientset(fake.NewSimpleClientset(tt.objects...)), WithNetnsResolver(resolver),
+				withProcRoot(newFakeProcRoot(t, tt.resolvConf)))
+			if tt.wantErr {
+				if err == nil {
+					s.Close()
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error creating session: %v", err)
+			}
+			defer s.Close()
+			tt.check(t, s.cfg, resolver)
+		})
+	}
+}
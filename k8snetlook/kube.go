@@ -1,97 +1,367 @@
 package k8snetlook
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"time"
 
 	log "github.com/sarun87/k8snetlook/logutil"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"golang.org/x/net/context"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-var clientset *kubernetes.Clientset
-
-func initKubernetesClient(kubeconfigPath string) error {
+func newKubernetesClient(logger log.Logger, kubeconfigPath string, timeout time.Duration) (kubernetes.Interface, error) {
 	// check if running in-cluster. If so initialize client-set using incluster method
 	config, err := rest.InClusterConfig()
 	if err != nil {
-		log.Debug("Not running in Pod or unable to fetch config via incluster method. Error:%v", err)
-		log.Debug("Trying from kubeconfig specified via command line flag")
+		logger.Debug("Not running in Pod or unable to fetch config via incluster method. Error:%v", err)
+		logger.Debug("Trying from kubeconfig specified via command line flag")
 		// Fall back to using config provided as part of command line arguments
 		// use the current context in kubeconfig
 		if kubeconfigPath == "" {
-			return fmt.Errorf("Not running in Pod & kubeconfig not specified using -config flag")
+			return nil, fmt.Errorf("Not running in Pod & kubeconfig not specified using -config flag")
 		}
 		config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	config.Timeout = time.Second * 4
-	clientset, err = kubernetes.NewForConfig(config)
+	config.Timeout = timeout
+	return kubernetes.NewForConfig(config)
+}
+
+func (e *Env) getService(ctx context.Context, namespace string, serviceName string) (*corev1.Service, error) {
+	service, err := e.Client.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
 	if err != nil {
-		return err
+		e.Log.Error("Error fetching %s service in %s ns. Error: %v", serviceName, namespace, err)
+		return nil, err
 	}
-	return nil
+	return service, nil
 }
 
-func getServiceClusterIP(namespace string, serviceName string) (Endpoint, error) {
-	service, err := clientset.CoreV1().Services(namespace).Get(context.TODO(), serviceName, metav1.GetOptions{})
+func (e *Env) getServiceClusterIP(ctx context.Context, namespace string, serviceName string) (Endpoint, error) {
+	service, err := e.getService(ctx, namespace, serviceName)
 	if err != nil {
-		log.Error("Error fetching %s service in %s ns. Error: %v", serviceName, namespace, err)
 		return Endpoint{}, err
 	}
-	// Return one port only
-	return Endpoint{IP: service.Spec.ClusterIP, Port: service.Spec.Ports[0].Port}, nil
+	return Endpoint{IP: service.Spec.ClusterIP, Ports: getServicePorts(service)}, nil
+}
+
+// getServicePorts returns all of the ports exposed by the service
+func getServicePorts(service *corev1.Service) []Port {
+	var ret []Port
+	for _, port := range service.Spec.Ports {
+		ret = append(ret, Port{
+			Name:       port.Name,
+			Port:       port.Port,
+			Protocol:   protocolOrDefault(port.Protocol),
+			NodePort:   port.NodePort,
+			TargetPort: port.TargetPort.String(),
+		})
+	}
+	return ret
+}
+
+// getLoadBalancerIngress returns the IPs or hostnames assigned to the service by the load balancer
+func getLoadBalancerIngress(service *corev1.Service) []string {
+	var ret []string
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			ret = append(ret, ingress.IP)
+		} else if ingress.Hostname != "" {
+			ret = append(ret, ingress.Hostname)
+		}
+	}
+	return ret
 }
 
-func getPodIPFromName(namespace string, podName string) string {
-	// if namespace == "" i.e metav1.NamespaceAll, then all pods are listed
-	pod, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+func (e *Env) getNodes(ctx context.Context) ([]Node, error) {
+	nodes, err := e.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		e.Log.Error("Error fetching nodes. Error: %v", err)
+		return nil, err
+	}
+	var ret []Node
+	for _, node := range nodes.Items {
+		n := Node{Name: node.Name}
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == corev1.NodeInternalIP {
+				n.IP = addr.Address
+				break
+			}
+			if addr.Type == corev1.NodeExternalIP && n.IP == "" {
+				n.IP = addr.Address
+			}
+		}
+		ret = append(ret, n)
+	}
+	return ret, nil
+}
+
+// localNodeName returns the name of the node k8snetlook is run on. The node of the
+// SrcPod is used if known. Otherwise the node is looked up using the host's IPs
+func localNodeName(nodes []Node, srcPodNodeName string) string {
+	if srcPodNodeName != "" {
+		return srcPodNodeName
+	}
+	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		log.Error("Error fetching %s pod in %s ns. Error: %v", podName, namespace, err)
 		return ""
 	}
-	return pod.Status.PodIP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		for _, node := range nodes {
+			if node.IP == ipNet.IP.String() {
+				return node.Name
+			}
+		}
+	}
+	return ""
 }
 
-func getContainerIDFromPod(namespace string, podName string) string {
-	// if namespace == "" i.e metav1.NamespaceAll, then all pods are listed
-	pod, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+// getNodeLocalDNSDaemonSet returns the name of the NodeLocal DNSCache DaemonSet in kube-system.
+// Returns an empty string if not deployed
+func (e *Env) getNodeLocalDNSDaemonSet(ctx context.Context) string {
+	daemonSets, err := e.Client.AppsV1().DaemonSets("kube-system").List(ctx, metav1.ListOptions{
+		LabelSelector: "k8s-app=" + nodeLocalDNSName,
+	})
 	if err != nil {
-		log.Error("Error fetching %s pod in %s ns. Error: %v", podName, namespace, err)
+		e.Log.Debug("Unable to list %s daemonsets. Error: %v", nodeLocalDNSName, err)
 		return ""
 	}
-	// Pod should have alteast one container (pause)
-	return pod.Status.ContainerStatuses[0].ContainerID
+	if len(daemonSets.Items) == 0 {
+		return ""
+	}
+	return daemonSets.Items[0].Name
+}
+
+// getNetworkPolicies returns the NetworkPolicies of all of the namespaces
+func (e *Env) getNetworkPolicies(ctx context.Context, namespaces ...string) ([]networkingv1.NetworkPolicy, error) {
+	var ret []networkingv1.NetworkPolicy
+	seen := map[string]bool{}
+	for _, namespace := range namespaces {
+		if seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		policies, err := e.Client.NetworkingV1().NetworkPolicies(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			e.Log.Error("Error fetching networkpolicies in %s ns. Error: %v", namespace, err)
+			return nil, err
+		}
+		ret = append(ret, policies.Items...)
+	}
+	return ret, nil
+}
+
+// getNamespaceLabels returns the labels of the namespace
+func (e *Env) getNamespaceLabels(ctx context.Context, namespace string) (map[string]string, error) {
+	ns, err := e.Client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		e.Log.Error("Error fetching %s namespace. Error: %v", namespace, err)
+		return nil, err
+	}
+	return ns.Labels, nil
+}
+
+func (e *Env) getPod(ctx context.Context, namespace string, podName string) (*corev1.Pod, error) {
+	pod, err := e.Client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		e.Log.Error("Error fetching %s pod in %s ns. Error: %v", podName, namespace, err)
+		return nil, err
+	}
+	return pod, nil
+}
+
+// getPodPorts returns the ports declared by all of the containers in the pod
+func getPodPorts(pod *corev1.Pod) []Port {
+	var ret []Port
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			ret = append(ret, Port{Name: port.Name, Port: port.ContainerPort, Protocol: protocolOrDefault(port.Protocol)})
+		}
+	}
+	return ret
+}
+
+// protocolOrDefault returns the protocol defaulting to TCP if not set
+func protocolOrDefault(protocol corev1.Protocol) string {
+	if protocol == "" {
+		return string(corev1.ProtocolTCP)
+	}
+	return string(protocol)
 }
 
-func getEndpointsFromService(namespace string, serviceName string) []Endpoint {
+// getEndpointsFromService returns all of the endpoints of the service including the ones
+// that aren't ready. EndpointSlices are used if available. Falls back to Endpoints
+func (e *Env) getEndpointsFromService(ctx context.Context, namespace string, serviceName string) []Endpoint {
+	slices, err := e.Client.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + serviceName,
+	})
+	if err == nil && len(slices.Items) > 0 {
+		return getEndpointsFromSlices(slices.Items)
+	}
+	if err != nil {
+		e.Log.Debug("Unable to list %s service endpointslices in %s ns. Falling back to endpoints. Error: %v", serviceName, namespace, err)
+	}
 	var ret []Endpoint
-	endpoints, err := clientset.CoreV1().Endpoints(namespace).Get(context.TODO(), serviceName, metav1.GetOptions{})
+	endpoints, err := e.Client.CoreV1().Endpoints(namespace).Get(ctx, serviceName, metav1.GetOptions{})
 	if err != nil {
-		log.Error("Error fetching %s service endpoints in %s ns. Error: %v", serviceName, namespace, err)
+		e.Log.Error("Error fetching %s service endpoints in %s ns. Error: %v", serviceName, namespace, err)
 		return ret
 	}
+	// An address is part of multiple subsets if it exposes different sets of ports.
+	// Merge the ports of such addresses into a single endpoint
+	index := map[string]int{}
 	for _, subset := range endpoints.Subsets {
-		for _, ip := range subset.Addresses {
-			for _, port := range subset.Ports {
-				ret = append(ret, Endpoint{IP: ip.IP, Port: port.Port})
+		var ports []Port
+		for _, port := range subset.Ports {
+			ports = append(ports, Port{Name: port.Name, Port: port.Port, Protocol: protocolOrDefault(port.Protocol)})
+		}
+		for _, addr := range subset.Addresses {
+			ep := Endpoint{IP: addr.IP, Ports: ports, Hostname: addr.Hostname, Ready: true, Serving: true}
+			if addr.NodeName != nil {
+				ep.NodeName = *addr.NodeName
+			}
+			ret = mergeEndpoint(ret, index, ep)
+		}
+		for _, addr := range subset.NotReadyAddresses {
+			ep := Endpoint{IP: addr.IP, Ports: ports, Hostname: addr.Hostname}
+			if addr.NodeName != nil {
+				ep.NodeName = *addr.NodeName
 			}
+			ret = mergeEndpoint(ret, index, ep)
 		}
 	}
 	return ret
 }
 
-func getSvcAccountToken() (string, error) {
-	svcAccount, err := clientset.CoreV1().ServiceAccounts("default").Get(context.TODO(), "default", metav1.GetOptions{})
+// getEndpointsFromSlices returns the endpoints of IPv4 & IPv6 EndpointSlices
+func getEndpointsFromSlices(slices []discoveryv1.EndpointSlice) []Endpoint {
+	var ret []Endpoint
+	index := map[string]int{}
+	for _, slice := range slices {
+		if slice.AddressType != discoveryv1.AddressTypeIPv4 && slice.AddressType != discoveryv1.AddressTypeIPv6 {
+			continue
+		}
+		var ports []Port
+		for _, port := range slice.Ports {
+			if port.Port == nil {
+				continue
+			}
+			p := Port{Port: *port.Port, Protocol: string(corev1.ProtocolTCP)}
+			if port.Name != nil {
+				p.Name = *port.Name
+			}
+			if port.Protocol != nil {
+				p.Protocol = protocolOrDefault(*port.Protocol)
+			}
+			ports = append(ports, p)
+		}
+		for _, endpoint := range slice.Endpoints {
+			// A nil condition is an unknown state that consumers interpret as ready.
+			// Serving defers to ready if not set
+			conditions := endpoint.Conditions
+			ep := Endpoint{Ports: ports, Ready: conditions.Ready == nil || *conditions.Ready}
+			ep.Serving = ep.Ready
+			if conditions.Serving != nil {
+				ep.Serving = *conditions.Serving
+			}
+			ep.Terminating = conditions.Terminating != nil && *conditions.Terminating
+			if endpoint.NodeName != nil {
+				ep.NodeName = *endpoint.NodeName
+			}
+			if endpoint.Hostname != nil {
+				ep.Hostname = *endpoint.Hostname
+			}
+			if endpoint.Zone != nil {
+				ep.Zone = *endpoint.Zone
+			}
+			if endpoint.Hints != nil {
+				for _, zone := range endpoint.Hints.ForZones {
+					ep.ZoneHints = append(ep.ZoneHints, zone.Name)
+				}
+			}
+			for _, addr := range endpoint.Addresses {
+				ep.IP = addr
+				ret = mergeEndpoint(ret, index, ep)
+			}
+		}
+	}
+	return ret
+}
+
+// mergeEndpoint appends ep to endpoints. If an endpoint with the same IP is found using
+// index, ep is merged into it instead. index maps IPs to positions in endpoints. An
+// address listed in several slices is ready if it's ready in any of them & then only
+// keeps the ports it's ready for. Ports are de-duplicated by name, port & protocol
+func mergeEndpoint(endpoints []Endpoint, index map[string]int, ep Endpoint) []Endpoint {
+	i, found := index[ep.IP]
+	if !found {
+		ep.Ports = appendUniquePorts(nil, ep.Ports)
+		index[ep.IP] = len(endpoints)
+		return append(endpoints, ep)
+	}
+	existing := &endpoints[i]
+	switch {
+	case ep.Ready && !existing.Ready:
+		existing.Ready, existing.Serving, existing.Terminating = true, ep.Serving, ep.Terminating
+		existing.Ports = appendUniquePorts(nil, ep.Ports)
+	case existing.Ready && !ep.Ready:
+		// Ports the address isn't ready for aren't probed
+	default:
+		existing.Serving = existing.Serving || ep.Serving
+		existing.Terminating = existing.Terminating && ep.Terminating
+		existing.Ports = appendUniquePorts(existing.Ports, ep.Ports)
+	}
+	return endpoints
+}
+
+// appendUniquePorts appends the ports that aren't in dst yet to dst
+func appendUniquePorts(dst []Port, ports []Port) []Port {
+	for _, port := range ports {
+		duplicate := false
+		for _, p := range dst {
+			if p.Name == port.Name && p.Port == port.Port && p.Protocol == port.Protocol {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			dst = append(dst, port)
+		}
+	}
+	return dst
+}
+
+// splitEndpoints splits endpoints into ready endpoints & endpoints that aren't ready
+func splitEndpoints(endpoints []Endpoint) ([]Endpoint, []Endpoint) {
+	var ready, notReady []Endpoint
+	for _, ep := range endpoints {
+		if ep.Ready {
+			ready = append(ready, ep)
+		} else {
+			notReady = append(notReady, ep)
+		}
+	}
+	return ready, notReady
+}
+
+func (e *Env) getSvcAccountToken(ctx context.Context) (string, error) {
+	svcAccount, err := e.Client.CoreV1().ServiceAccounts("default").Get(ctx, "default", metav1.GetOptions{})
 	if err != nil {
 		return "", fmt.Errorf("Error fetching default service acccount. Error: %s", err)
 	}
-	secret, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), svcAccount.Secrets[0].Name, metav1.GetOptions{})
+	secret, err := e.Client.CoreV1().Secrets("default").Get(ctx, svcAccount.Secrets[0].Name, metav1.GetOptions{})
 	if err != nil {
 		return "", fmt.Errorf("Error fetching secret for service account. Error: %s", err)
 	}
@@ -0,0 +1,109 @@
+package k8snetlook
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/sarun87/k8snetlook/netutils"
+)
+
+// Chains & prefixes of the nat table rules programmed by kube-proxy in iptables mode
+const (
+	kubeServicesChain    = "KUBE-SERVICES"
+	kubeSvcChainPrefix   = "KUBE-SVC-"
+	kubeSvlChainPrefix   = "KUBE-SVL-" // Chains of services with a Local traffic policy
+	kubeSepChainPrefix   = "KUBE-SEP-"
+	kubeProxyNoEndpoints = "has no endpoints"
+)
+
+// kubeProxyServiceRules describes the rules kube-proxy programmed for a ClusterIP:port
+type kubeProxyServiceRules struct {
+	SvcChain  string   // KUBE-SVC-* chain KUBE-SERVICES jumps to. Empty if not found
+	Endpoints []string // "ip:port" DNAT destinations of the KUBE-SEP-* chains
+	// Rejected is set if kube-proxy rejects traffic because the service has no endpoints
+	Rejected bool
+}
+
+// findKubeProxyServiceRules follows the KUBE-SERVICES rule matching clusterIP & port to
+// its KUBE-SVC-* chain & collects the DNAT destinations of the KUBE-SEP-* chains
+func findKubeProxyServiceRules(chains map[string][]netutils.IPTablesRule, clusterIP string, port Port) (kubeProxyServiceRules, error) {
+	var ret kubeProxyServiceRules
+	if _, ok := chains[kubeServicesChain]; !ok {
+		return ret, fmt.Errorf("%s chain not found. kube-proxy may not be running in iptables mode", kubeServicesChain)
+	}
+	if !hasChainWithPrefix(chains, kubeSvcChainPrefix) {
+		return ret, fmt.Errorf("no %s* chains found. kube-proxy may be running in IPVS mode", kubeSvcChainPrefix)
+	}
+	ip := net.ParseIP(clusterIP)
+	if ip == nil {
+		return ret, fmt.Errorf("invalid ClusterIP %q", clusterIP)
+	}
+	// REJECT rules for services without endpoints are in the filter table of newer
+	// kube-proxy versions. Older versions add them to KUBE-SERVICES of the nat table
+	ret.Rejected = kubeProxyRejectsService(chains, clusterIP, port)
+	for _, rule := range chains[kubeServicesChain] {
+		target := rule.Target()
+		if ruleMatchesService(rule, ip, port) && (strings.HasPrefix(target, kubeSvcChainPrefix) || strings.HasPrefix(target, kubeSvlChainPrefix)) {
+			ret.SvcChain = target
+		}
+	}
+	if ret.SvcChain == "" {
+		return ret, nil
+	}
+	seen := map[string]bool{}
+	for _, rule := range chains[ret.SvcChain] {
+		sep := rule.Target()
+		if !strings.HasPrefix(sep, kubeSepChainPrefix) {
+			continue
+		}
+		for _, sepRule := range chains[sep] {
+			if sepRule.Target() != "DNAT" {
+				continue
+			}
+			if dst := sepRule.Arg("--to-destination"); dst != "" && !seen[dst] {
+				seen[dst] = true
+				ret.Endpoints = append(ret.Endpoints, dst)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// kubeProxyRejectsService checks if KUBE-SERVICES of the nat or filter table chains has a
+// rule rejecting traffic to clusterIP & port because the service has no endpoints
+func kubeProxyRejectsService(chains map[string][]netutils.IPTablesRule, clusterIP string, port Port) bool {
+	ip := net.ParseIP(clusterIP)
+	for _, rule := range chains[kubeServicesChain] {
+		if ruleMatchesService(rule, ip, port) && (rule.Target() == "REJECT" || strings.Contains(rule.Arg("--comment"), kubeProxyNoEndpoints)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleMatchesService checks if the rule matches traffic destined to ip:port
+func ruleMatchesService(rule netutils.IPTablesRule, ip net.IP, port Port) bool {
+	dst := rule.Arg("-d")
+	if dst == "" {
+		return false
+	}
+	dstIP, _, err := net.ParseCIDR(dst)
+	if err != nil {
+		dstIP = net.ParseIP(dst)
+	}
+	return dstIP.Equal(ip) &&
+		strings.EqualFold(rule.Arg("-p"), port.Protocol) &&
+		rule.Arg("--dport") == strconv.Itoa(int(port.Port))
+}
+
+// hasChainWithPrefix checks if any of the chains is named with prefix
+func hasChainWithPrefix(chains map[string][]netutils.IPTablesRule, prefix string) bool {
+	for name := range chains {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
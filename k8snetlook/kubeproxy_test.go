@@ -0,0 +1,86 @@
+package k8snetlook
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sarun87/k8snetlook/netutils"
+)
+
+const testKubeProxyNat = `*nat
+:KUBE-SERVICES - [0:0]
+:KUBE-SVC-ERIFXISQEP7F7OF4 - [0:0]
+:KUBE-SEP-AAAAAAAAAAAAAAAA - [0:0]
+:KUBE-SEP-BBBBBBBBBBBBBBBB - [0:0]
+-A KUBE-SERVICES -d 10.96.0.10/32 -p tcp -m comment --comment "kube-system/kube-dns:dns-tcp cluster IP" -m tcp --dport 53 -j KUBE-SVC-ERIFXISQEP7F7OF4
+-A KUBE-SERVICES -d 10.96.0.20/32 -p tcp -m comment --comment "default/empty:http has no endpoints" -m tcp --dport 80 -j REJECT
+-A KUBE-SVC-ERIFXISQEP7F7OF4 ! -s 10.244.0.0/16 -d 10.96.0.10/32 -p tcp -m tcp --dport 53 -j KUBE-MARK-MASQ
+-A KUBE-SVC-ERIFXISQEP7F7OF4 -m comment --comment "kube-system/kube-dns:dns-tcp -> 10.244.0.2:53" -m statistic --mode random --probability 0.5 -j KUBE-SEP-AAAAAAAAAAAAAAAA
+-A KUBE-SVC-ERIFXISQEP7F7OF4 -m comment --comment "kube-system/kube-dns:dns-tcp -> 10.244.1.3:53" -j KUBE-SEP-BBBBBBBBBBBBBBBB
+-A KUBE-SEP-AAAAAAAAAAAAAAAA -s 10.244.0.2/32 -j KUBE-MARK-MASQ
+-A KUBE-SEP-AAAAAAAAAAAAAAAA -p tcp -m tcp -j DNAT --to-destination 10.244.0.2:53
+-A KUBE-SEP-BBBBBBBBBBBBBBBB -p tcp -m tcp -j DNAT --to-destination 10.244.1.3:53
+COMMIT
+`
+
+func TestFindKubeProxyServiceRules(t *testing.T) {
+	chains, err := netutils.ParseIPTablesSave(testKubeProxyNat, "nat")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rules, err := findKubeProxyServiceRules(chains, "10.96.0.10", Port{Name: "dns-tcp", Port: 53, Protocol: "TCP"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := kubeProxyServiceRules{SvcChain: "KUBE-SVC-ERIFXISQEP7F7OF4", Endpoints: []string{"10.244.0.2:53", "10.244.1.3:53"}}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Expected %+v. Got: %+v", expected, rules)
+	}
+	// Protocol must match
+	if rules, _ := findKubeProxyServiceRules(chains, "10.96.0.10", Port{Port: 53, Protocol: "UDP"}); rules.SvcChain != "" {
+		t.Errorf("Expected no rules for UDP. Got: %+v", rules)
+	}
+	if rules, _ := findKubeProxyServiceRules(chains, "10.96.0.20", Port{Port: 80, Protocol: "TCP"}); !rules.Rejected || rules.SvcChain != "" {
+		t.Errorf("Expected service without endpoints to be rejected. Got: %+v", rules)
+	}
+	ipvs, _ := netutils.ParseIPTablesSave("*nat\n:KUBE-SERVICES - [0:0]\nCOMMIT\n", "nat")
+	if _, err := findKubeProxyServiceRules(ipvs, "10.96.0.10", Port{Port: 53, Protocol: "TCP"}); err == nil {
+		t.Errorf("Expected error without KUBE-SVC chains")
+	}
+}
+
+// Newer kube-proxy versions add REJECT rules for services without endpoints to the filter table
+const testKubeProxyFilter = `*filter
+:KUBE-SERVICES - [0:0]
+:KUBE-EXTERNAL-SERVICES - [0:0]
+-A KUBE-SERVICES -d 10.96.0.30/32 -p tcp -m comment --comment "default/empty:http has no endpoints" -m tcp --dport 80 -j REJECT --reject-with icmp-port-unreachable
+COMMIT
+`
+
+func TestKubeProxyRejectsService(t *testing.T) {
+	filter, err := netutils.ParseIPTablesSave(testKubeProxyFilter, "filter")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	nat, _ := netutils.ParseIPTablesSave(testKubeProxyNat, "nat")
+	tests := []struct {
+		name      string
+		chains    map[string][]netutils.IPTablesRule
+		clusterIP string
+		port      Port
+		want      bool
+	}{
+		{name: "filter table", chains: filter, clusterIP: "10.96.0.30", port: Port{Port: 80, Protocol: "TCP"}, want: true},
+		{name: "filter table, other port", chains: filter, clusterIP: "10.96.0.30", port: Port{Port: 443, Protocol: "TCP"}, want: false},
+		{name: "nat table", chains: nat, clusterIP: "10.96.0.20", port: Port{Port: 80, Protocol: "TCP"}, want: true},
+		{name: "nat table, service with endpoints", chains: nat, clusterIP: "10.96.0.10", port: Port{Port: 53, Protocol: "TCP"}, want: false},
+		{name: "filter table not read", chains: nil, clusterIP: "10.96.0.30", port: Port{Port: 80, Protocol: "TCP"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := kubeProxyRejectsService(tt.chains, tt.clusterIP, tt.port); got != tt.want {
+				t.Errorf("Expected %v. Got: %v", tt.want, got)
+			}
+		})
+	}
+}
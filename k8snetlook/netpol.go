@@ -0,0 +1,172 @@
+package k8snetlook
+
+import (
+	"net"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// policyPod describes a pod as seen by NetworkPolicy rules
+type policyPod struct {
+	Namespace       string
+	IP              string
+	Labels          map[string]string
+	NamespaceLabels map[string]string
+}
+
+// policyVerdict is the outcome of evaluating NetworkPolicies for traffic in one direction
+type policyVerdict struct {
+	Isolated  bool     // Pod is selected by at least one policy for the direction
+	Allowed   bool     // Traffic is allowed for the direction
+	Selecting []string // namespace/name of the policies that select the pod for the direction
+	AllowedBy []string // namespace/name of the policies with a rule allowing the traffic
+}
+
+// evaluateNetworkPolicies evaluates policies for traffic sent by src to port of dst. Ingress
+// rules are evaluated on dst & egress rules on src. Traffic must be allowed in both
+// directions. A zero port stands for traffic without ports, eg: ICMP, which is only
+// matched by rules that don't list ports
+func evaluateNetworkPolicies(policies []networkingv1.NetworkPolicy, src, dst policyPod, port Port) (policyVerdict, policyVerdict) {
+	var ingress, egress policyVerdict
+	for _, policy := range policies {
+		name := policy.Namespace + "/" + policy.Name
+		if policyApplies(policy, networkingv1.PolicyTypeIngress) && selectsPod(policy, dst) {
+			ingress.Isolated = true
+			ingress.Selecting = append(ingress.Selecting, name)
+			for _, rule := range policy.Spec.Ingress {
+				if peersMatch(rule.From, policy.Namespace, src) && portsMatch(rule.Ports, port) {
+					ingress.AllowedBy = append(ingress.AllowedBy, name)
+					break
+				}
+			}
+		}
+		if policyApplies(policy, networkingv1.PolicyTypeEgress) && selectsPod(policy, src) {
+			egress.Isolated = true
+			egress.Selecting = append(egress.Selecting, name)
+			for _, rule := range policy.Spec.Egress {
+				if peersMatch(rule.To, policy.Namespace, dst) && portsMatch(rule.Ports, port) {
+					egress.AllowedBy = append(egress.AllowedBy, name)
+					break
+				}
+			}
+		}
+	}
+	for _, v := range []*policyVerdict{&ingress, &egress} {
+		v.Allowed = !v.Isolated || len(v.AllowedBy) > 0
+		sort.Strings(v.Selecting)
+		sort.Strings(v.AllowedBy)
+	}
+	return ingress, egress
+}
+
+// policyApplies checks if the policy isolates pods for the direction. Policies without
+// policyTypes always apply to ingress & apply to egress only if they have egress rules
+func policyApplies(policy networkingv1.NetworkPolicy, direction networkingv1.PolicyType) bool {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return direction == networkingv1.PolicyTypeIngress || len(policy.Spec.Egress) > 0
+	}
+	for _, t := range policy.Spec.PolicyTypes {
+		if t == direction {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsPod checks if the pod is in the namespace of the policy & matches its podSelector
+func selectsPod(policy networkingv1.NetworkPolicy, pod policyPod) bool {
+	return policy.Namespace == pod.Namespace && selectorMatches(&policy.Spec.PodSelector, pod.Labels)
+}
+
+// peersMatch checks if any of the peers matches pod. An empty list of peers matches all
+// sources/destinations. policyNamespace is used for peers without a namespaceSelector
+func peersMatch(peers []networkingv1.NetworkPolicyPeer, policyNamespace string, pod policyPod) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, peer := range peers {
+		if peer.IPBlock != nil {
+			if ipBlockMatches(peer.IPBlock, pod.IP) {
+				return true
+			}
+			continue
+		}
+		if peer.NamespaceSelector == nil {
+			if pod.Namespace != policyNamespace {
+				continue
+			}
+		} else if !selectorMatches(peer.NamespaceSelector, pod.NamespaceLabels) {
+			continue
+		}
+		if peer.PodSelector == nil || selectorMatches(peer.PodSelector, pod.Labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipBlockMatches checks if ip is within the CIDR of the block & not within any of its exceptions
+func ipBlockMatches(block *networkingv1.IPBlock, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	if _, cidr, err := net.ParseCIDR(block.CIDR); err != nil || !cidr.Contains(addr) {
+		return false
+	}
+	for _, except := range block.Except {
+		if _, cidr, err := net.ParseCIDR(except); err == nil && cidr.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// portsMatch checks if any of the policy ports matches port. An empty list matches all ports
+func portsMatch(ports []networkingv1.NetworkPolicyPort, port Port) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	if port.Port == 0 {
+		return false
+	}
+	for _, p := range ports {
+		protocol := corev1.ProtocolTCP
+		if p.Protocol != nil {
+			protocol = *p.Protocol
+		}
+		if string(protocol) != port.Protocol {
+			continue
+		}
+		switch {
+		case p.Port == nil:
+			return true
+		case p.Port.Type == intstr.String:
+			// Named ports refer to the container ports of the destination pod
+			if p.Port.StrVal == port.Name {
+				return true
+			}
+		case p.EndPort != nil:
+			if port.Port >= p.Port.IntVal && port.Port <= *p.EndPort {
+				return true
+			}
+		case p.Port.IntVal == port.Port:
+			return true
+		}
+	}
+	return false
+}
+
+// selectorMatches checks if the label selector matches set. An empty selector matches everything
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
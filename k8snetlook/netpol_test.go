@@ -0,0 +1,128 @@
+package k8snetlook
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestEvaluateNetworkPolicies(t *testing.T) {
+	src := policyPod{Namespace: "frontend", IP: "10.244.1.5", Labels: map[string]string{"app": "web"},
+		NamespaceLabels: map[string]string{"team": "frontend"}}
+	dst := policyPod{Namespace: "backend", IP: "10.244.2.7", Labels: map[string]string{"app": "db"},
+		NamespaceLabels: map[string]string{"team": "backend"}}
+	dbPort := Port{Name: "postgres", Port: 5432, Protocol: "TCP"}
+	metricsPort := Port{Name: "metrics", Port: 9187, Protocol: "TCP"}
+	udp := corev1.ProtocolUDP
+	endPort := int32(5500)
+
+	denyAll := networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "backend", Name: "deny-all"},
+		Spec:       networkingv1.NetworkPolicySpec{PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}},
+	}
+	allowFrontend := func(ports ...networkingv1.NetworkPolicyPort) networkingv1.NetworkPolicy {
+		return networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "backend", Name: "allow-frontend"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "frontend"}},
+						PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+					}},
+					Ports: ports,
+				}},
+			},
+		}
+	}
+	namedPort := intstr.FromString("postgres")
+	numberedPort := intstr.FromInt(5400)
+	egressToOtherCIDR := networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "frontend", Name: "egress-cidr"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{{
+					IPBlock: &networkingv1.IPBlock{CIDR: "10.244.0.0/16", Except: []string{"10.244.2.0/24"}},
+				}},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		policies []networkingv1.NetworkPolicy
+		port     Port
+		ingress  policyVerdict
+		egress   policyVerdict
+	}{
+		{
+			name:    "no policies",
+			port:    dbPort,
+			ingress: policyVerdict{Allowed: true},
+			egress:  policyVerdict{Allowed: true},
+		},
+		{
+			name:     "deny all ingress",
+			policies: []networkingv1.NetworkPolicy{denyAll},
+			port:     dbPort,
+			ingress:  policyVerdict{Isolated: true, Selecting: []string{"backend/deny-all"}},
+			egress:   policyVerdict{Allowed: true},
+		},
+		{
+			name:     "allowed by named port",
+			policies: []networkingv1.NetworkPolicy{denyAll, allowFrontend(networkingv1.NetworkPolicyPort{Port: &namedPort})},
+			port:     dbPort,
+			ingress: policyVerdict{Isolated: true, Allowed: true, Selecting: []string{"backend/allow-frontend", "backend/deny-all"},
+				AllowedBy: []string{"backend/allow-frontend"}},
+			egress: policyVerdict{Allowed: true},
+		},
+		{
+			name:     "port not listed",
+			policies: []networkingv1.NetworkPolicy{allowFrontend(networkingv1.NetworkPolicyPort{Port: &namedPort})},
+			port:     metricsPort,
+			ingress:  policyVerdict{Isolated: true, Selecting: []string{"backend/allow-frontend"}},
+			egress:   policyVerdict{Allowed: true},
+		},
+		{
+			name:     "port range",
+			policies: []networkingv1.NetworkPolicy{allowFrontend(networkingv1.NetworkPolicyPort{Port: &numberedPort, EndPort: &endPort})},
+			port:     dbPort,
+			ingress:  policyVerdict{Isolated: true, Allowed: true, Selecting: []string{"backend/allow-frontend"}, AllowedBy: []string{"backend/allow-frontend"}},
+			egress:   policyVerdict{Allowed: true},
+		},
+		{
+			name:     "protocol mismatch",
+			policies: []networkingv1.NetworkPolicy{allowFrontend(networkingv1.NetworkPolicyPort{Protocol: &udp})},
+			port:     dbPort,
+			ingress:  policyVerdict{Isolated: true, Selecting: []string{"backend/allow-frontend"}},
+			egress:   policyVerdict{Allowed: true},
+		},
+		{
+			name:     "traffic without ports needs a rule without ports",
+			policies: []networkingv1.NetworkPolicy{allowFrontend(networkingv1.NetworkPolicyPort{Port: &namedPort}), egressToOtherCIDR},
+			ingress:  policyVerdict{Isolated: true, Selecting: []string{"backend/allow-frontend"}},
+			egress:   policyVerdict{Isolated: true, Selecting: []string{"frontend/egress-cidr"}},
+		},
+		{
+			name:     "ipBlock except",
+			policies: []networkingv1.NetworkPolicy{allowFrontend(), egressToOtherCIDR},
+			port:     dbPort,
+			ingress:  policyVerdict{Isolated: true, Allowed: true, Selecting: []string{"backend/allow-frontend"}, AllowedBy: []string{"backend/allow-frontend"}},
+			egress:   policyVerdict{Isolated: true, Selecting: []string{"frontend/egress-cidr"}},
+		},
+	}
+	for _, tt := range tests {
+		ingress, egress := evaluateNetworkPolicies(tt.policies, src, dst, tt.port)
+		if !reflect.DeepEqual(ingress, tt.ingress) {
+			t.Errorf("%s: ingress: expected %+v. Got: %+v", tt.name, tt.ingress, ingress)
+		}
+		if !reflect.DeepEqual(egress, tt.egress) {
+			t.Errorf("%s: egress: expected %+v. Got: %+v", tt.name, tt.egress, egress)
+		}
+	}
+}
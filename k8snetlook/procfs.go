@@ -0,0 +1,96 @@
+package k8snetlook
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultProcRoot = "/proc"
+
+// procfsResolver locates the processes of a pod by scanning the cgroup
+// membership of every process under procRoot. It does not depend on the
+// container runtime & works as long as the host pid namespace is visible
+type procfsResolver struct {
+	procRoot string
+}
+
+// findPodPids returns the pids, sorted in ascending order, of all the
+// processes that belong to the pod with the specified uid
+func (p procfsResolver) findPodPids(podUID string) ([]int, error) {
+	if podUID == "" {
+		return nil, fmt.Errorf("pod uid not specified")
+	}
+	// cgroupfs driver uses the pod uid as is, eg: /kubepods/besteffort/pod<uid>/<id>
+	// systemd driver replaces '-' with '_', eg: /kubepods.slice/kubepods-pod<uid>.slice/<id>.scope
+	patterns := []string{
+		"pod" + podUID,
+		"pod" + strings.ReplaceAll(podUID, "-", "_"),
+	}
+	entries, err := os.ReadDir(p.procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", p.procRoot, err)
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			// Not a process directory. Eg: /proc/self, /proc/net
+			continue
+		}
+		// Processes can exit while /proc is being scanned. Ignore read errors
+		if found, _ := cgroupMatches(filepath.Join(p.procRoot, entry.Name(), "cgroup"), patterns); found {
+			pids = append(pids, pid)
+		}
+	}
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("no process found in %s for pod uid %s", p.procRoot, podUID)
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// netnsPath returns path to the network namespace file of the process
+func (p procfsResolver) netnsPath(pid int) string {
+	return filepath.Join(p.procRoot, strconv.Itoa(pid), "ns", "net")
+}
+
+// rootPath returns the path of the root filesystem of the process
+func (p procfsResolver) rootPath(pid int) string {
+	return filepath.Join(p.procRoot, strconv.Itoa(pid), "root")
+}
+
+// resolvConfPath returns the path of the resolv.conf in the root filesystem of the process
+func (p procfsResolver) resolvConfPath(pid int) string {
+	return filepath.Join(p.rootPath(pid), "etc", "resolv.conf")
+}
+
+// cgroupMatches checks if any of the cgroup paths in the cgroup file
+// contains one of the patterns as a path component prefix
+func cgroupMatches(cgroupFile string, patterns []string) (bool, error) {
+	f, err := os.Open(cgroupFile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Line format: hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, component := range strings.Split(fields[2], "/") {
+			for _, pattern := range patterns {
+				if component == pattern || strings.HasSuffix(component, "-"+pattern+".slice") {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,69 @@
+package k8snetlook
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testPodUID = "2c0b1e6a-5d4f-4a3b-9c7e-1f2a3b4c5d6e"
+
+// writeFakeProc creates a fake procfs root with cgroup files for the pids specified
+func writeFakeProc(t *testing.T, cgroups map[string]string) string {
+	root := t.TempDir()
+	for pid, cgroup := range cgroups {
+		dir := filepath.Join(root, pid)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte(cgroup), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestFindPodPidsCgroupfs(t *testing.T) {
+	root := writeFakeProc(t, map[string]string{
+		"1":    "12:pids:/init.scope\n0::/init.scope\n",
+		"4242": "11:memory:/kubepods/besteffort/pod" + testPodUID + "/abcdef\n",
+		"4200": "11:memory:/kubepods/besteffort/pod" + testPodUID + "/012345\n",
+		"4300": "11:memory:/kubepods/besteffort/pod0000/abcdef\n",
+		"self": "11:memory:/kubepods/besteffort/pod" + testPodUID + "/abcdef\n",
+	})
+	r := procfsResolver{procRoot: root}
+	pids, err := r.findPodPids(testPodUID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(pids, []int{4200, 4242}) {
+		t.Errorf("Expected pids [4200 4242]. Got: %v", pids)
+	}
+	if path := r.netnsPath(pids[0]); path != filepath.Join(root, "4200", "ns", "net") {
+		t.Errorf("Unexpected netns path: %s", path)
+	}
+}
+
+func TestFindPodPidsSystemd(t *testing.T) {
+	root := writeFakeProc(t, map[string]string{
+		"1":   "0::/init.scope\n",
+		"977": "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod2c0b1e6a_5d4f_4a3b_9c7e_1f2a3b4c5d6e.slice/cri-containerd-abcdef.scope\n",
+	})
+	pids, err := procfsResolver{procRoot: root}.findPodPids(testPodUID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(pids, []int{977}) {
+		t.Errorf("Expected pids [977]. Got: %v", pids)
+	}
+}
+
+func TestFindPodPidsNotFound(t *testing.T) {
+	root := writeFakeProc(t, map[string]string{
+		"1": "0::/init.scope\n",
+	})
+	if _, err := (procfsResolver{procRoot: root}).findPodPids(testPodUID); err == nil {
+		t.Errorf("Expected an error when no process belongs to the pod")
+	}
+}
@@ -0,0 +1,285 @@
+package k8snetlook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sarun87/k8snetlook/logutil"
+	"github.com/vishvananda/netns"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Scope specifies the network namespace(s) that a checker runs from
+type Scope int
+
+const (
+	// ScopeHost checkers run from the host network namespace
+	ScopeHost Scope = 1 << iota
+	// ScopePod checkers run from within the SrcPod network namespace
+	ScopePod
+)
+
+func (s Scope) String() string {
+	var scopes []string
+	if s&ScopeHost != 0 {
+		scopes = append(scopes, "host")
+	}
+	if s&ScopePod != 0 {
+		scopes = append(scopes, "pod")
+	}
+	return strings.Join(scopes, ",")
+}
+
+// Prerequisite specifies user input that a checker requires in order to run
+type Prerequisite string
+
+const (
+	// PrereqDstPod requires the destination pod to be specified
+	PrereqDstPod Prerequisite = "dstpod"
+	// PrereqDstSvc requires the destination service to be specified
+	PrereqDstSvc Prerequisite = "dstsvc"
+	// PrereqDstSvcClusterIP requires the destination service to have a ClusterIP, ie: not
+	// to be headless
+	PrereqDstSvcClusterIP Prerequisite = "dstsvc-clusterip"
+	// PrereqExternalIP requires the external ip to be specified
+	PrereqExternalIP Prerequisite = "externalip"
+	// PrereqExternalHost requires the external host name to be specified
+	PrereqExternalHost Prerequisite = "externalhost"
+	// PrereqDstSvcNodePort requires the destination service to expose NodePorts
+	PrereqDstSvcNodePort Prerequisite = "dstsvc-nodeport"
+	// PrereqDstSvcLoadBalancer requires the destination service to be of type LoadBalancer
+	PrereqDstSvcLoadBalancer Prerequisite = "dstsvc-loadbalancer"
+	// PrereqDstSvcExternalName requires the destination service to be of type ExternalName
+	PrereqDstSvcExternalName Prerequisite = "dstsvc-externalname"
+	// PrereqNodeLocalDNS requires NodeLocal DNSCache to be deployed
+	PrereqNodeLocalDNS Prerequisite = "nodelocaldns"
+)
+
+// Env describes the environment a checker is run in
+type Env struct {
+	Cfg    *Config
+	Client kubernetes.Interface
+	Log    log.Logger
+	Scope  Scope // Scope the checker is currently run from. Either ScopeHost or ScopePod
+	// HostNsHandle is a handle to the host netns when running pod checks.
+	// netns.None() when running host checks
+	HostNsHandle netns.NsHandle
+
+	flows   *flowRecorder // Records the flows opened by probes. May be nil
+	running string        // Name of the checker being run
+}
+
+// satisfies checks if the prerequisite is met by the environment
+func (e *Env) satisfies(p Prerequisite) bool {
+	switch p {
+	case PrereqDstPod:
+		return e.Cfg.DstPod.IP != ""
+	case PrereqDstSvc:
+		return e.Cfg.DstSvc.ClusterIP != ""
+	case PrereqDstSvcClusterIP:
+		return e.Cfg.DstSvc.ClusterIP != "" && e.Cfg.DstSvc.ClusterIP != "None"
+	case PrereqExternalIP:
+		return e.Cfg.ExternalIP != ""
+	case PrereqExternalHost:
+		return e.Cfg.ExternalHost != ""
+	case PrereqDstSvcNodePort:
+		return e.Cfg.DstSvc.hasNodePorts()
+	case PrereqDstSvcLoadBalancer:
+		return e.Cfg.DstSvc.Type == string(corev1.ServiceTypeLoadBalancer)
+	case PrereqDstSvcExternalName:
+		return e.Cfg.DstSvc.Type == string(corev1.ServiceTypeExternalName)
+	case PrereqNodeLocalDNS:
+		return e.Cfg.NodeLocalDNS.enabled()
+	}
+	return false
+}
+
+// Result describes the outcome of running a checker
+type Result struct {
+	Success bool
+	// Inconclusive is set when the check could neither pass nor fail. Eg: no reply to a UDP probe
+	Inconclusive bool
+	Err          error
+	Details      []string // Per target results reported along with the check
+}
+
+// addDetail adds a formatted line to the details of the result
+func (r *Result) addDetail(format string, v ...interface{}) {
+	r.Details = append(r.Details, fmt.Sprintf(format, v...))
+}
+
+// newResult converts the (pass, err) return values of a Run*Check function to a Result
+func newResult(pass bool, err error) Result {
+	return Result{Success: pass, Err: err}
+}
+
+// Checker is implemented by all of the network checks run by k8snetlook
+type Checker interface {
+	// Name returns a short unique name used to select the checker
+	Name() string
+	// Description returns the description of the checker printed in the report
+	Description() string
+	// Scope returns the network namespace(s) the checker can be run from
+	Scope() Scope
+	// Prerequisites returns the user input required by the checker
+	Prerequisites() []Prerequisite
+	// Run runs the check and returns the result
+	Run(ctx context.Context, env *Env) Result
+}
+
+// checker implements Checker using a function to run the check
+type checker struct {
+	name          string
+	description   string
+	scope         Scope
+	prerequisites []Prerequisite
+	run           func(ctx context.Context, env *Env) Result
+	// runLast is set for checkers that inspect the state left behind by the other
+	// checkers of the scope. Eg: conntrack entries of the probes
+	runLast bool
+}
+
+func (c *checker) Name() string                             { return c.name }
+func (c *checker) Description() string                      { return c.description }
+func (c *checker) Scope() Scope                             { return c.scope }
+func (c *checker) Prerequisites() []Prerequisite            { return c.prerequisites }
+func (c *checker) Run(ctx context.Context, env *Env) Result { return c.run(ctx, env) }
+
+// runsLast checks if c must be run after all of the other checkers
+func runsLast(c Checker) bool {
+	impl, ok := c.(*checker)
+	return ok && impl.runLast
+}
+
+// registry holds all of the registered checkers in the order of registration
+var registry []Checker
+
+// Register adds a checker to the registry. Panics if a checker with the same name
+// is already registered
+func Register(c Checker) {
+	if _, found := lookupChecker(c.Name()); found {
+		panic(fmt.Sprintf("checker %s registered twice", c.Name()))
+	}
+	registry = append(registry, c)
+}
+
+// RegisteredCheckers returns all of the registered checkers
+func RegisteredCheckers() []Checker {
+	return append([]Checker{}, registry...)
+}
+
+// checkTimeout returns the timeout for the checker with the name specified
+func (c *Config) checkTimeout(name string) time.Duration {
+	if timeout, found := c.CheckTimeouts[name]; found {
+		return timeout
+	}
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultCheckTimeout
+}
+
+// ValidateCheckNames returns an error if any of the names isn't a registered checker
+func ValidateCheckNames(names []string) error {
+	for _, name := range names {
+		if _, found := lookupChecker(name); !found {
+			return fmt.Errorf("unknown check %q. See 'k8snetlook list-checks' for valid checks", name)
+		}
+	}
+	return nil
+}
+
+func lookupChecker(name string) (Checker, bool) {
+	for _, c := range registry {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// selectCheckers returns the checkers to be run in the environment based on scope,
+// prerequisites & the checks selected or skipped by the user
+func selectCheckers(env *Env) []Checker {
+	var ret, last []Checker
+	for _, c := range registry {
+		if c.Scope()&env.Scope == 0 {
+			continue
+		}
+		if len(env.Cfg.Checks) > 0 && !containsString(env.Cfg.Checks, c.Name()) {
+			continue
+		}
+		if containsString(env.Cfg.SkipChecks, c.Name()) {
+			env.Log.Debug("Skipping check %s", c.Name())
+			continue
+		}
+		satisfied := true
+		for _, p := range c.Prerequisites() {
+			if !env.satisfies(p) {
+				satisfied = false
+				break
+			}
+		}
+		if !satisfied {
+			continue
+		}
+		if runsLast(c) {
+			last = append(last, c)
+		} else {
+			ret = append(ret, c)
+		}
+	}
+	return append(ret, last...)
+}
+
+// runCheckers runs all of the checkers selected for the environment. Each checker
+// is run with its own timeout. If ctx is cancelled, the checkers that did not
+// finish are reported as cancelled
+func runCheckers(ctx context.Context, env *Env) []Check {
+	var checks []Check
+	from := "Host"
+	if env.Scope == ScopePod {
+		from = "SrcPod"
+	}
+	for _, c := range selectCheckers(env) {
+		if ctx.Err() != nil {
+			checks = append(checks, Check{Name: c.Description(), Status: StatusCancelled, ErrorMsg: ctx.Err()})
+			continue
+		}
+		env.Log.Debug("----> [From %s] Running %s..", from, c.Description())
+		env.running = c.Name()
+		checkCtx, cancel := context.WithTimeout(ctx, env.Cfg.checkTimeout(c.Name()))
+		res := c.Run(checkCtx, env)
+		cancel()
+		checks = append(checks, newCheck(ctx, c.Description(), res))
+	}
+	return checks
+}
+
+// newCheck converts the result of a checker to a Check for reporting
+func newCheck(ctx context.Context, name string, res Result) Check {
+	check := Check{Name: name, Success: res.Success, ErrorMsg: res.Err, Status: StatusFailed, Details: res.Details}
+	switch {
+	case ctx.Err() != nil:
+		// Run was interrupted. Result is incomplete
+		check.Success = false
+		check.Status = StatusCancelled
+	case res.Success:
+		check.Status = StatusPassed
+	case res.Inconclusive:
+		check.Status = StatusInconclusive
+	}
+	return check
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
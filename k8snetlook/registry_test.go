@@ -0,0 +1,84 @@
+package k8snetlook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sarun87/k8snetlook/logutil"
+)
+
+func checkerNames(checkers []Checker) []string {
+	var names []string
+	for _, c := range checkers {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+func TestSelectCheckers(t *testing.T) {
+	cfg := &Config{SkipChecks: []string{"kubeapi-endpoints"}}
+	env := &Env{Cfg: cfg, Log: log.New(log.ERROR), Scope: ScopeHost}
+	names := checkerNames(selectCheckers(env))
+	if containsString(names, "kubeapi-endpoints") {
+		t.Errorf("Skipped check selected: %v", names)
+	}
+	if !containsString(names, "kubeapi-health") || containsString(names, "dns-kubernetes") {
+		t.Errorf("Checks not selected based on scope: %v", names)
+	}
+
+	env.Scope = ScopePod
+	if names = checkerNames(selectCheckers(env)); containsString(names, "dstpod-connectivity") {
+		t.Errorf("Check selected without DstPod being specified: %v", names)
+	}
+	cfg.DstPod.IP = "10.244.1.5"
+	cfg.Checks = []string{"dstpod-connectivity"}
+	if names = checkerNames(selectCheckers(env)); len(names) != 1 || names[0] != "dstpod-connectivity" {
+		t.Errorf("Expected only dstpod-connectivity to be selected. Got: %v", names)
+	}
+}
+
+func TestValidateCheckNames(t *testing.T) {
+	if err := ValidateCheckNames([]string{"gateway-connectivity", "dstpod-pmtu"}); err != nil {
+		t.Errorf("Unexpected error for registered checks: %v", err)
+	}
+	if err := ValidateCheckNames([]string{"gateway-connectivity", "no-such-check"}); err == nil {
+		t.Errorf("Expected error for unknown check")
+	}
+}
+
+func TestRunCheckersCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env := &Env{Cfg: &Config{Checks: []string{"kubeapi-health"}}, Log: log.New(log.ERROR), Scope: ScopeHost}
+	checks := runCheckers(ctx, env)
+	if len(checks) != 1 {
+		t.Fatalf("Expected 1 check. Got: %v", checks)
+	}
+	if checks[0].Success || checks[0].Status != StatusCancelled {
+		t.Errorf("Expected check to be cancelled. Got: %+v", checks[0])
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	cfg := &Config{}
+	if timeout := cfg.checkTimeout("dstpod-pmtu"); timeout != DefaultCheckTimeout {
+		t.Errorf("Expected default timeout. Got: %v", timeout)
+	}
+	cfg.Timeout = 10 * time.Second
+	cfg.CheckTimeouts = map[string]time.Duration{"dstpod-pmtu": time.Minute}
+	if timeout := cfg.checkTimeout("dstpod-pmtu"); timeout != time.Minute {
+		t.Errorf("Expected per check timeout override. Got: %v", timeout)
+	}
+	if timeout := cfg.checkTimeout("gateway-connectivity"); timeout != 10*time.Second {
+		t.Errorf("Expected global timeout. Got: %v", timeout)
+	}
+}
+
+func TestSelectCheckersRunLast(t *testing.T) {
+	env := &Env{Cfg: &Config{}, Log: log.New(log.ERROR), Scope: ScopePod}
+	names := checkerNames(selectCheckers(env))
+	if len(names) == 0 || names[len(names)-1] != "conntrack" {
+		t.Errorf("Expected conntrack to be selected last. Got: %v", names)
+	}
+}
@@ -0,0 +1,237 @@
+package k8snetlook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// Container runtimes are detected using the scheme of the ContainerID
+// reported as part of the pod status. Eg: containerd://<container-id>
+const (
+	runtimeDocker     = "docker"
+	runtimeContainerd = "containerd"
+	runtimeCRIO       = "cri-o"
+
+	criDialTimeout    = 4 * time.Second
+	criRequestTimeout = 4 * time.Second
+)
+
+// defaultRuntimeEndpoints lists the default CRI socket path for each CRI runtime
+var defaultRuntimeEndpoints = map[string]string{
+	runtimeContainerd: "/run/containerd/containerd.sock",
+	runtimeCRIO:       "/var/run/crio/crio.sock",
+}
+
+// NetnsResolver looks up the process of a pod. The network namespace & the files of the
+// pod are accessed through the process. Eg: /proc/<pid>/ns/net, /proc/<pid>/root/etc/resolv.conf
+type NetnsResolver interface {
+	// GetPodPid returns the pid of a process running within the pod
+	GetPodPid(ctx context.Context, pod *corev1.Pod) (int, error)
+}
+
+// defaultNetnsResolver looks up the pod's processes using the container runtime
+// & falls back to scanning procfs if the runtime isn't reachable
+type defaultNetnsResolver struct {
+	runtimeEndpoint string
+	procfs          procfsResolver
+}
+
+// NewNetnsResolver returns the default NetnsResolver. runtimeEndpoint, if not
+// empty, overrides the default CRI socket path of the pod's container runtime
+func NewNetnsResolver(runtimeEndpoint string) NetnsResolver {
+	return &defaultNetnsResolver{
+		runtimeEndpoint: runtimeEndpoint,
+		procfs:          procfsResolver{procRoot: defaultProcRoot},
+	}
+}
+
+func (r *defaultNetnsResolver) GetPodPid(ctx context.Context, pod *corev1.Pod) (int, error) {
+	pid, runtimeErr := r.getPidFromRuntime(ctx, pod)
+	if runtimeErr == nil {
+		return pid, nil
+	}
+	// Runtime socket may not be mounted or the runtime isn't supported.
+	// Fall back to locating the pod's processes using procfs
+	pid, err := r.getPidFromProcfs(pod)
+	if err != nil {
+		return 0, fmt.Errorf("container runtime: %v, procfs: %v", runtimeErr, err)
+	}
+	return pid, nil
+}
+
+// getPidFromRuntime fetches the pid of the pod's first container using the container
+// runtime that runs the pod's containers
+func (r *defaultNetnsResolver) getPidFromRuntime(ctx context.Context, pod *corev1.Pod) (int, error) {
+	// Pod should have alteast one container (pause)
+	if len(pod.Status.ContainerStatuses) == 0 || pod.Status.ContainerStatuses[0].ContainerID == "" {
+		return 0, fmt.Errorf("unable to fetch container id for pod %s", pod.Name)
+	}
+	runtimeName, containerID, err := parseContainerID(pod.Status.ContainerStatuses[0].ContainerID)
+	if err != nil {
+		return 0, err
+	}
+	rt, err := newContainerRuntime(ctx, runtimeName, r.runtimeEndpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer rt.Close()
+	return rt.GetContainerPid(ctx, containerID)
+}
+
+// getPidFromProcfs fetches the pid of a process that belongs to the pod's cgroup.
+// Processes with a resolv.conf are preferred since the pause container may not have one
+func (r *defaultNetnsResolver) getPidFromProcfs(pod *corev1.Pod) (int, error) {
+	pids, err := r.procfs.findPodPids(string(pod.UID))
+	if err != nil {
+		return 0, err
+	}
+	for _, pid := range pids {
+		if _, err := os.Stat(r.procfs.resolvConfPath(pid)); err == nil {
+			return pid, nil
+		}
+	}
+	return pids[0], nil
+}
+
+// ContainerRuntime is implemented by each container runtime backend
+type ContainerRuntime interface {
+	// GetContainerPid returns the pid of a process running within the container.
+	// The process shares the network namespace of the pod sandbox
+	GetContainerPid(ctx context.Context, containerID string) (int, error)
+	// Close releases the connection to the container runtime
+	Close() error
+}
+
+// parseContainerID splits a pod status ContainerID of the form <runtime>://<id>
+// into the runtime name & the container id
+func parseContainerID(containerID string) (string, string, error) {
+	parts := strings.SplitN(containerID, "://", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("unable to parse container id %q", containerID)
+	}
+	return parts[0], parts[1], nil
+}
+
+// newContainerRuntime returns a ContainerRuntime for the runtime specified.
+// endpoint, if not empty, overrides the default socket path of the CRI runtimes
+func newContainerRuntime(ctx context.Context, runtimeName, endpoint string) (ContainerRuntime, error) {
+	switch runtimeName {
+	case runtimeDocker:
+		return newDockerRuntime()
+	case runtimeContainerd, runtimeCRIO:
+		if endpoint == "" {
+			endpoint = defaultRuntimeEndpoints[runtimeName]
+		}
+		return newCRIRuntime(ctx, endpoint)
+	}
+	return nil, fmt.Errorf("unsupported container runtime %q", runtimeName)
+}
+
+// dockerRuntime looks up containers using the docker daemon
+type dockerRuntime struct {
+	cli *client.Client
+}
+
+func newDockerRuntime() (*dockerRuntime, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("unable to create docker client: %v", err)
+	}
+	return &dockerRuntime{cli: cli}, nil
+}
+
+func (d *dockerRuntime) GetContainerPid(ctx context.Context, containerID string) (int, error) {
+	containerJSON, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return -1, fmt.Errorf("unable to inspect container: %v", err)
+	}
+	return containerJSON.State.Pid, nil
+}
+
+func (d *dockerRuntime) Close() error {
+	return d.cli.Close()
+}
+
+// criRuntime looks up containers using the CRI gRPC api exposed by
+// containerd & cri-o over a unix socket
+type criRuntime struct {
+	conn   *grpc.ClientConn
+	client criapi.RuntimeServiceClient
+}
+
+// criVerboseInfo holds the fields of interest from the verbose "info"
+// returned by the CRI runtimes as part of ContainerStatus & PodSandboxStatus
+type criVerboseInfo struct {
+	SandboxID string `json:"sandboxID"`
+	Pid       int    `json:"pid"`
+}
+
+func newCRIRuntime(ctx context.Context, endpoint string) (*criRuntime, error) {
+	// Fail fast if the socket isn't mounted rather than waiting on dial timeout
+	if _, err := os.Stat(endpoint); err != nil {
+		return nil, fmt.Errorf("container runtime socket not found: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, criDialTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "unix://"+endpoint, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to container runtime at %s: %v", endpoint, err)
+	}
+	return &criRuntime{conn: conn, client: criapi.NewRuntimeServiceClient(conn)}, nil
+}
+
+func (c *criRuntime) GetContainerPid(ctx context.Context, containerID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, criRequestTimeout)
+	defer cancel()
+	containerStatus, err := c.client.ContainerStatus(ctx, &criapi.ContainerStatusRequest{
+		ContainerId: containerID, Verbose: true})
+	if err != nil {
+		return -1, fmt.Errorf("unable to fetch container status: %v", err)
+	}
+	info, err := parseCRIVerboseInfo(containerStatus.Info)
+	if err != nil {
+		return -1, err
+	}
+	if info.Pid > 0 {
+		return info.Pid, nil
+	}
+	// Container info did not include the pid. Use the pid of the pod sandbox instead
+	sandboxStatus, err := c.client.PodSandboxStatus(ctx, &criapi.PodSandboxStatusRequest{
+		PodSandboxId: info.SandboxID, Verbose: true})
+	if err != nil {
+		return -1, fmt.Errorf("unable to fetch pod sandbox status: %v", err)
+	}
+	info, err = parseCRIVerboseInfo(sandboxStatus.Info)
+	if err != nil {
+		return -1, err
+	}
+	if info.Pid <= 0 {
+		return -1, fmt.Errorf("container runtime did not report a pid for container %s", containerID)
+	}
+	return info.Pid, nil
+}
+
+func (c *criRuntime) Close() error {
+	return c.conn.Close()
+}
+
+func parseCRIVerboseInfo(info map[string]string) (criVerboseInfo, error) {
+	var ret criVerboseInfo
+	raw, ok := info["info"]
+	if !ok {
+		return ret, fmt.Errorf("container runtime did not return verbose info")
+	}
+	if err := json.Unmarshal([]byte(raw), &ret); err != nil {
+		return ret, fmt.Errorf("unable to parse verbose info from container runtime: %v", err)
+	}
+	return ret, nil
+}
@@ -11,6 +11,14 @@ import (
 	"os"
 )
 
+// Logger is the interface used by k8snetlook to log messages. MyLogger
+// implements Logger. Library users can plug in their own implementation
+type Logger interface {
+	Debug(format string, v ...interface{})
+	Info(format string, v ...interface{})
+	Error(format string, v ...interface{})
+}
+
 type MyLogger struct {
 	*log.Logger
 	level int // One of DEBUG, ERROR, INFO
@@ -22,30 +30,28 @@ const (
 	ERROR
 )
 
-var myLog MyLogger
+// New returns a logger that writes messages at or above lvl to stdout
+func New(lvl int) *MyLogger {
+	return &MyLogger{Logger: log.New(os.Stdout, "", 0), level: lvl}
+}
 
-func Error(format string, v ...interface{}) {
-	if myLog.level <= ERROR {
+func (l *MyLogger) Error(format string, v ...interface{}) {
+	if l.level <= ERROR {
 		s := fmt.Sprintf("ERROR: "+format, v...)
-		myLog.Output(2, s)
+		l.Output(2, s)
 	}
 }
 
-func Info(format string, v ...interface{}) {
-	if myLog.level <= INFO {
+func (l *MyLogger) Info(format string, v ...interface{}) {
+	if l.level <= INFO {
 		s := fmt.Sprintf(format, v...)
-		myLog.Output(2, s)
+		l.Output(2, s)
 	}
 }
 
-func Debug(format string, v ...interface{}) {
-	if myLog.level <= DEBUG {
+func (l *MyLogger) Debug(format string, v ...interface{}) {
+	if l.level <= DEBUG {
 		s := fmt.Sprintf("DEBUG: "+format, v...)
-		myLog.Output(2, s)
+		l.Output(2, s)
 	}
 }
-
-func SetLogLevel(lvl int) {
-	myLog.level = lvl
-	myLog.Logger = log.New(os.Stdout, "", 0)
-}
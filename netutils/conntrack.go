@@ -0,0 +1,314 @@
+package netutils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// Flow identifies a connection using the tuple of its original direction
+type Flow struct {
+	Protocol string // One of TCP, UDP
+	SrcIP    string
+	SrcPort  uint16
+	DstIP    string
+	DstPort  uint16
+}
+
+// NewFlow returns a Flow given the local & remote "ip:port" of a connection
+func NewFlow(protocol, localAddr, remoteAddr string) (Flow, error) {
+	flow := Flow{Protocol: protocol}
+	var err error
+	if flow.SrcIP, flow.SrcPort, err = splitHostPort(localAddr); err != nil {
+		return flow, err
+	}
+	if flow.DstIP, flow.DstPort, err = splitHostPort(remoteAddr); err != nil {
+		return flow, err
+	}
+	return flow, nil
+}
+
+func (f Flow) String() string {
+	return fmt.Sprintf("%s %s -> %s", f.Protocol,
+		net.JoinHostPort(f.SrcIP, strconv.Itoa(int(f.SrcPort))),
+		net.JoinHostPort(f.DstIP, strconv.Itoa(int(f.DstPort))))
+}
+
+// Attributes of ctnetlink messages that aren't defined by the nl package
+const (
+	ipctnlMsgCtGetStats             = 5      // IPCTNL_MSG_CT_GET_STATS
+	ctaStatsGlobalEntries           = 1      // CTA_STATS_GLOBAL_ENTRIES
+	ctaStatsGlobalMaxEntries        = 2      // CTA_STATS_GLOBAL_MAX_ENTRIES
+	ipsSeenReply             uint32 = 1 << 1 // IPS_SEEN_REPLY status bit
+)
+
+// ConntrackEntry describes the conntrack table entry of a Flow
+type ConntrackEntry struct {
+	Flow
+	// ReplySrcIP & ReplySrcPort is where replies for the flow are expected from.
+	// Differs from the flow's destination if DNAT was applied
+	ReplySrcIP   string
+	ReplySrcPort uint16
+	Status       uint32 // IPS_* status bits of the entry
+}
+
+// DNATed checks if the destination of the flow was translated
+func (e ConntrackEntry) DNATed() bool {
+	return e.ReplySrcIP != e.DstIP || e.ReplySrcPort != e.DstPort
+}
+
+// Unreplied checks if no packets were seen in the reply direction. Same as the
+// [UNREPLIED] flag listed by the conntrack tool
+func (e ConntrackEntry) Unreplied() bool {
+	return e.Status&ipsSeenReply == 0
+}
+
+// ReplySrc returns "ip:port" that replies for the flow are expected from
+func (e ConntrackEntry) ReplySrc() string {
+	return net.JoinHostPort(e.ReplySrcIP, strconv.Itoa(int(e.ReplySrcPort)))
+}
+
+// LookupConntrack dumps the conntrack table of the network namespace specified by
+// nsHandle & returns the entries matching flows, keyed by flow. Flows without
+// a matching entry are not part of the result. Use netns.None() for current netns
+func LookupConntrack(nsHandle netns.NsHandle, flows []Flow) (map[Flow]ConntrackEntry, error) {
+	ret := map[Flow]ConntrackEntry{}
+	if len(flows) == 0 {
+		return ret, nil
+	}
+	families := map[uint8]bool{}
+	for _, flow := range flows {
+		if net.ParseIP(flow.DstIP).To4() != nil {
+			families[unix.AF_INET] = true
+		} else {
+			families[unix.AF_INET6] = true
+		}
+	}
+	for family := range families {
+		req := nl.NewNetlinkRequest((unix.NFNL_SUBSYS_CTNETLINK<<8)|nl.IPCTNL_MSG_CT_GET, unix.NLM_F_DUMP)
+		req.AddData(&nl.Nfgenmsg{NfgenFamily: family, Version: nl.NFNETLINK_V0})
+		msgs, err := executeConntrackRequest(nsHandle, req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list conntrack table: %v", err)
+		}
+		var entries []ConntrackEntry
+		for _, msg := range msgs {
+			entry, err := parseConntrackEntry(msg)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		for flow, entry := range matchConntrackFlows(entries, flows) {
+			ret[flow] = entry
+		}
+	}
+	return ret, nil
+}
+
+// executeConntrackRequest sends req on a netfilter netlink socket opened in the network
+// namespace specified by nsHandle. Returns the payloads of the response messages
+func executeConntrackRequest(nsHandle netns.NsHandle, req *nl.NetlinkRequest) ([][]byte, error) {
+	sock, err := nl.GetNetlinkSocketAt(nsHandle, netns.None(), unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open netlink socket: %v", err)
+	}
+	defer sock.Close()
+	if err := sock.SetReceiveTimeout(&nl.SocketTimeoutTv); err != nil {
+		return nil, err
+	}
+	req.Sockets = map[int]*nl.SocketHandle{unix.NETLINK_NETFILTER: {Socket: sock}}
+	return req.Execute(unix.NETLINK_NETFILTER, 0)
+}
+
+// parseConntrackEntry parses the payload of a ctnetlink message describing an entry
+func parseConntrackEntry(msg []byte) (ConntrackEntry, error) {
+	var entry ConntrackEntry
+	attrs, err := parseNfAttrs(msg)
+	if err != nil {
+		return entry, fmt.Errorf("invalid conntrack entry: %v", err)
+	}
+	for _, attr := range attrs {
+		switch attrType(attr) {
+		case nl.CTA_TUPLE_ORIG:
+			if entry.Flow, err = parseConntrackTuple(attr.Value); err != nil {
+				return entry, err
+			}
+		case nl.CTA_TUPLE_REPLY:
+			reply, err := parseConntrackTuple(attr.Value)
+			if err != nil {
+				return entry, err
+			}
+			entry.ReplySrcIP, entry.ReplySrcPort = reply.SrcIP, reply.SrcPort
+		case nl.CTA_STATUS:
+			if len(attr.Value) < 4 {
+				return entry, fmt.Errorf("invalid conntrack entry status")
+			}
+			entry.Status = binary.BigEndian.Uint32(attr.Value)
+		}
+	}
+	return entry, nil
+}
+
+// parseConntrackTuple parses the nested attributes of CTA_TUPLE_ORIG or CTA_TUPLE_REPLY
+func parseConntrackTuple(data []byte) (Flow, error) {
+	var flow Flow
+	attrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return flow, fmt.Errorf("invalid conntrack tuple: %v", err)
+	}
+	for _, attr := range attrs {
+		switch attrType(attr) {
+		case nl.CTA_TUPLE_IP:
+			ips, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return flow, fmt.Errorf("invalid conntrack tuple: %v", err)
+			}
+			for _, ip := range ips {
+				switch attrType(ip) {
+				case nl.CTA_IP_V4_SRC, nl.CTA_IP_V6_SRC:
+					flow.SrcIP = net.IP(ip.Value).String()
+				case nl.CTA_IP_V4_DST, nl.CTA_IP_V6_DST:
+					flow.DstIP = net.IP(ip.Value).String()
+				}
+			}
+		case nl.CTA_TUPLE_PROTO:
+			protos, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return flow, fmt.Errorf("invalid conntrack tuple: %v", err)
+			}
+			for _, proto := range protos {
+				switch {
+				case attrType(proto) == nl.CTA_PROTO_NUM && len(proto.Value) >= 1:
+					flow.Protocol = protocolName(proto.Value[0])
+				case attrType(proto) == nl.CTA_PROTO_SRC_PORT && len(proto.Value) >= 2:
+					flow.SrcPort = binary.BigEndian.Uint16(proto.Value)
+				case attrType(proto) == nl.CTA_PROTO_DST_PORT && len(proto.Value) >= 2:
+					flow.DstPort = binary.BigEndian.Uint16(proto.Value)
+				}
+			}
+		}
+	}
+	return flow, nil
+}
+
+// matchConntrackFlows returns the entries whose original direction matches one of flows,
+// keyed by flow
+func matchConntrackFlows(entries []ConntrackEntry, flows []Flow) map[Flow]ConntrackEntry {
+	wanted := map[Flow]bool{}
+	for _, flow := range flows {
+		wanted[flow] = true
+	}
+	ret := map[Flow]ConntrackEntry{}
+	for _, entry := range entries {
+		if wanted[entry.Flow] {
+			ret[entry.Flow] = entry
+		}
+	}
+	return ret
+}
+
+// ConntrackUsage returns the number of conntrack entries in the network namespace specified
+// by nsHandle & the maximum number of entries the table can hold. The maximum is read from
+// nf_conntrack_max under procRoot if the kernel doesn't report it. Use netns.None() for
+// current netns
+func ConntrackUsage(nsHandle netns.NsHandle, procRoot string) (int, int, error) {
+	// The kernel flags the response as multipart without terminating it. The ack ends it
+	req := nl.NewNetlinkRequest((unix.NFNL_SUBSYS_CTNETLINK<<8)|ipctnlMsgCtGetStats, unix.NLM_F_ACK)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: unix.AF_UNSPEC, Version: nl.NFNETLINK_V0})
+	msgs, err := executeConntrackRequest(nsHandle, req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to read conntrack stats: %v", err)
+	}
+	if len(msgs) == 0 {
+		return 0, 0, fmt.Errorf("no conntrack stats received")
+	}
+	count, maxCount, err := parseConntrackStats(msgs[0])
+	if err != nil || maxCount > 0 {
+		return count, maxCount, err
+	}
+	max, err := os.ReadFile(filepath.Join(procRoot, "sys/net/netfilter/nf_conntrack_max"))
+	if err != nil {
+		return 0, 0, err
+	}
+	if maxCount, err = strconv.Atoi(strings.TrimSpace(string(max))); err != nil {
+		return 0, 0, fmt.Errorf("invalid nf_conntrack_max: %v", err)
+	}
+	return count, maxCount, nil
+}
+
+// parseConntrackStats returns the number of entries & the maximum number of entries from
+// the payload of a IPCTNL_MSG_CT_GET_STATS response. The maximum is 0 if not reported.
+// Older kernels only report the number of entries
+func parseConntrackStats(msg []byte) (int, int, error) {
+	attrs, err := parseNfAttrs(msg)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid conntrack stats: %v", err)
+	}
+	count, maxCount := -1, 0
+	for _, attr := range attrs {
+		if len(attr.Value) < 4 {
+			continue
+		}
+		switch attrType(attr) {
+		case ctaStatsGlobalEntries:
+			count = int(binary.BigEndian.Uint32(attr.Value))
+		case ctaStatsGlobalMaxEntries:
+			maxCount = int(binary.BigEndian.Uint32(attr.Value))
+		}
+	}
+	if count < 0 {
+		return 0, 0, fmt.Errorf("number of conntrack entries not reported")
+	}
+	return count, maxCount, nil
+}
+
+// parseNfAttrs parses the attributes following the nfgenmsg header of msg
+func parseNfAttrs(msg []byte) ([]syscall.NetlinkRouteAttr, error) {
+	if len(msg) < nl.SizeofNfgenmsg {
+		return nil, fmt.Errorf("message too short")
+	}
+	return nl.ParseRouteAttr(msg[nl.SizeofNfgenmsg:])
+}
+
+// attrType returns the type of attr without the nested & byte order flags
+func attrType(attr syscall.NetlinkRouteAttr) uint16 {
+	return attr.Attr.Type & nl.NLA_TYPE_MASK
+}
+
+func protocolName(proto uint8) string {
+	switch proto {
+	case unix.IPPROTO_TCP:
+		return "TCP"
+	case unix.IPPROTO_UDP:
+		return "UDP"
+	case unix.IPPROTO_SCTP:
+		return "SCTP"
+	}
+	return strconv.Itoa(int(proto))
+}
+
+func splitHostPort(addr string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %s", addr)
+	}
+	// Normalize IP representation so that flows can be compared
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	return host, uint16(port), nil
+}
@@ -0,0 +1,132 @@
+package netutils
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// newNfMsg returns the payload of a ctnetlink message with attrs
+func newNfMsg(attrs ...*nl.RtAttr) []byte {
+	msg := (&nl.Nfgenmsg{NfgenFamily: unix.AF_INET, Version: nl.NFNETLINK_V0}).Serialize()
+	for _, attr := range attrs {
+		msg = append(msg, attr.Serialize()...)
+	}
+	return msg
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func TestParseConntrackStats(t *testing.T) {
+	count, maxCount, err := parseConntrackStats(newNfMsg(
+		nl.NewRtAttr(ctaStatsGlobalEntries, be32(500)),
+		nl.NewRtAttr(ctaStatsGlobalMaxEntries, be32(262144))))
+	if err != nil || count != 500 || maxCount != 262144 {
+		t.Errorf("Expected 500/262144 entries. Got: %d/%d, error: %v", count, maxCount, err)
+	}
+	// Older kernels don't report the maximum
+	count, maxCount, err = parseConntrackStats(newNfMsg(nl.NewRtAttr(ctaStatsGlobalEntries, be32(500))))
+	if err != nil || count != 500 || maxCount != 0 {
+		t.Errorf("Expected 500 entries without maximum. Got: %d/%d, error: %v", count, maxCount, err)
+	}
+	if _, _, err := parseConntrackStats(newNfMsg()); err == nil {
+		t.Errorf("Expected error for stats without number of entries")
+	}
+}
+
+func TestNewFlow(t *testing.T) {
+	flow, err := NewFlow("TCP", "10.244.1.7:40000", "[fd00::1]:443")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Flow{Protocol: "TCP", SrcIP: "10.244.1.7", SrcPort: 40000, DstIP: "fd00::1", DstPort: 443}
+	if flow != expected {
+		t.Errorf("Expected %+v. Got: %+v", expected, flow)
+	}
+	if _, err := NewFlow("UDP", "10.244.1.7", "10.96.0.10:53"); err == nil {
+		t.Errorf("Expected error for address without port")
+	}
+}
+
+// newConntrackTuple returns a CTA_TUPLE_ORIG or CTA_TUPLE_REPLY attribute of a UDP flow
+func newConntrackTuple(attrType int, src string, srcPort uint16, dst string, dstPort uint16) *nl.RtAttr {
+	tuple := nl.NewRtAttr(attrType|unix.NLA_F_NESTED, nil)
+	ips := tuple.AddRtAttr(nl.CTA_TUPLE_IP|unix.NLA_F_NESTED, nil)
+	ips.AddRtAttr(nl.CTA_IP_V4_SRC, net.ParseIP(src).To4())
+	ips.AddRtAttr(nl.CTA_IP_V4_DST, net.ParseIP(dst).To4())
+	proto := tuple.AddRtAttr(nl.CTA_TUPLE_PROTO|unix.NLA_F_NESTED, nil)
+	proto.AddRtAttr(nl.CTA_PROTO_NUM, []byte{unix.IPPROTO_UDP})
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, srcPort)
+	proto.AddRtAttr(nl.CTA_PROTO_SRC_PORT, port)
+	port = make([]byte, 2)
+	binary.BigEndian.PutUint16(port, dstPort)
+	proto.AddRtAttr(nl.CTA_PROTO_DST_PORT, port)
+	return tuple
+}
+
+func TestParseConntrackEntry(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        uint32
+		wantUnreplied bool
+	}{
+		// IPS_CONFIRMED | IPS_SRC_NAT_DONE | IPS_DST_NAT_DONE | IPS_DST_NAT
+		{name: "unreplied", status: 1<<3 | 1<<7 | 1<<8 | 1<<5, wantUnreplied: true},
+		// Same with IPS_SEEN_REPLY
+		{name: "replied", status: 1<<3 | 1<<7 | 1<<8 | 1<<5 | 1<<1, wantUnreplied: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := parseConntrackEntry(newNfMsg(
+				newConntrackTuple(nl.CTA_TUPLE_ORIG, "10.244.1.7", 40000, "10.96.0.10", 53),
+				newConntrackTuple(nl.CTA_TUPLE_REPLY, "10.244.2.3", 53, "10.244.1.7", 40000),
+				nl.NewRtAttr(nl.CTA_STATUS, be32(tt.status))))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expected := Flow{Protocol: "UDP", SrcIP: "10.244.1.7", SrcPort: 40000, DstIP: "10.96.0.10", DstPort: 53}
+			if entry.Flow != expected || entry.ReplySrc() != "10.244.2.3:53" || !entry.DNATed() {
+				t.Errorf("Expected %+v DNAT'ed to 10.244.2.3:53. Got: %+v", expected, entry)
+			}
+			if entry.Unreplied() != tt.wantUnreplied {
+				t.Errorf("Expected unreplied %v for status %#x", tt.wantUnreplied, tt.status)
+			}
+		})
+	}
+}
+
+func TestMatchConntrackFlows(t *testing.T) {
+	newEntry := func(src string, srcPort uint16, dst string, dstPort uint16, replySrc string, replySrcPort uint16) ConntrackEntry {
+		return ConntrackEntry{
+			Flow:         Flow{Protocol: "UDP", SrcIP: src, SrcPort: srcPort, DstIP: dst, DstPort: dstPort},
+			ReplySrcIP:   replySrc,
+			ReplySrcPort: replySrcPort,
+		}
+	}
+	ctEntries := []ConntrackEntry{
+		newEntry("10.244.1.7", 40000, "10.96.0.10", 53, "10.244.2.3", 53),
+		newEntry("10.244.1.7", 40001, "10.244.2.3", 53, "10.244.2.3", 53),
+		newEntry("10.244.1.8", 40000, "10.96.0.10", 53, "10.244.2.4", 53),
+	}
+	dnat := Flow{Protocol: "UDP", SrcIP: "10.244.1.7", SrcPort: 40000, DstIP: "10.96.0.10", DstPort: 53}
+	direct := Flow{Protocol: "UDP", SrcIP: "10.244.1.7", SrcPort: 40001, DstIP: "10.244.2.3", DstPort: 53}
+	missing := Flow{Protocol: "TCP", SrcIP: "10.244.1.7", SrcPort: 40000, DstIP: "10.96.0.10", DstPort: 53}
+	entries := matchConntrackFlows(ctEntries, []Flow{dnat, direct, missing})
+	if len(entries) != 2 {
+		t.Fatalf("Expected entries of 2 flows. Got: %+v", entries)
+	}
+	if entry := entries[dnat]; !entry.DNATed() || entry.ReplySrc() != "10.244.2.3:53" {
+		t.Errorf("Expected flow DNAT'ed to 10.244.2.3:53. Got: %+v", entry)
+	}
+	if entry := entries[direct]; entry.DNATed() {
+		t.Errorf("Expected flow not to be DNAT'ed. Got: %+v", entry)
+	}
+}
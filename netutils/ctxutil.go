@@ -0,0 +1,35 @@
+package netutils
+
+import (
+	"context"
+	"time"
+)
+
+// deadlineConn is implemented by connections that support deadlines
+type deadlineConn interface {
+	SetDeadline(t time.Time) error
+}
+
+// probeDeadline returns the deadline for a single probe. timeout is the default
+// time a probe waits for; ctx can only shorten it
+func probeDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
+
+// watchContext unblocks pending reads & writes on conn when ctx is done.
+// The returned func must be called once conn is no longer in use
+func watchContext(ctx context.Context, conn deadlineConn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
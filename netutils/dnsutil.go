@@ -1,11 +1,80 @@
 package netutils
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
+const (
+	dnsTimeout = 4 * time.Second
+	// DefaultDNSRetries is the number of times a query is retried after a timeout
+	DefaultDNSRetries = 2
+	// DefaultEDNSBufferSize is the EDNS0 UDP buffer size advertised in queries. 1232 bytes
+	// avoids IP fragmentation on most paths (DNS flag day 2020)
+	DefaultEDNSBufferSize = 1232
+)
+
+// ErrNXDomain is returned when the queried name does not exist
+var ErrNXDomain = errors.New(dns.RcodeToString[dns.RcodeNameError])
+
+// DNSOptions configures how DNS queries are sent
+type DNSOptions struct {
+	Retries int    // Number of times a query is retried after a timeout
+	UDPSize uint16 // EDNS0 UDP buffer size advertised in queries. 0 disables EDNS0
+}
+
+// DefaultDNSOptions returns the options used by RunDNSLookupUsingCustomResolver
+func DefaultDNSOptions() DNSOptions {
+	return DNSOptions{Retries: DefaultDNSRetries, UDPSize: DefaultEDNSBufferSize}
+}
+
+// DNSQueryResult describes the outcome of a single DNS query
+type DNSQueryResult struct {
+	Name      string
+	Type      string        // Query type. Eg: A, AAAA
+	Rcode     string        // Response code. Empty if no response was received
+	Answers   []string      // Data of the answer records of the query type. Eg: IPs for A & AAAA
+	Latency   time.Duration // Time taken by the attempt that got a response
+	Attempts  int           // Number of attempts including retries & TCP fallback
+	Truncated bool          // UDP response was truncated & the query was retried over TCP
+	SRV       []SRVRecord   // Answer records of SRV queries
+	LocalAddr string        // Local ip:port the last attempt was sent from
+	Err       error
+}
+
+// SRVRecord is the data of an SRV answer record
+type SRVRecord struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// DNSLookupResult holds the results of the A & AAAA queries of a host lookup
+type DNSLookupResult struct {
+	A    DNSQueryResult
+	AAAA DNSQueryResult
+}
+
+// IPs returns the IPv4 & IPv6 addresses resolved
+func (r DNSLookupResult) IPs() []string {
+	return append(append([]string{}, r.A.Answers...), r.AAAA.Answers...)
+}
+
+// Err returns nil if either of the queries succeeded. Returns the error of the A query otherwise
+func (r DNSLookupResult) Err() error {
+	if r.A.Err == nil || r.AAAA.Err == nil {
+		return nil
+	}
+	return r.A.Err
+}
+
 // Does not work, not sure why :(
 /*func runDNSLookupUsingCustomResolver(dnsFQDN) ([]net.IPAddr, error) {
 	// Create a custom resolver since /etc/resolv.conf is the host's configuration
@@ -38,67 +107,168 @@ import (
 // code referenced from: https://github.com/bogdanovich/dns_resolver
 // nameserver string format: "ip:port"
 // hostFQDN string format: "abc.def.ghi."
-func RunDNSLookupUsingCustomResolver(nameserver, hostFQDN string) ([]string, error) {
-	// TODO: Add retries
-
-	result := []string{}
-
-	// Create DNS32 Message with single question
-	msg := &dns.Msg{
-		MsgHdr: dns.MsgHdr{
-			Id:               dns.Id(),
-			RecursionDesired: true,
-		},
-		Question: []dns.Question{{Name: dns.Fqdn(hostFQDN), Qtype: dns.TypeA, Qclass: dns.ClassINET}},
-	}
-
-	// Send question and add resolved ips to result
-	res, err := getIPFromDNSQuery(msg, nameserver)
-	if err != nil {
+// Each query waits for dnsTimeout or until ctx is done. Returns an error only
+// if both the A & AAAA queries fail
+func RunDNSLookupUsingCustomResolver(ctx context.Context, nameserver, hostFQDN string) ([]string, error) {
+	res := LookupHost(ctx, nameserver, hostFQDN, DefaultDNSOptions())
+	if err := res.Err(); err != nil {
 		return nil, err
 	}
-	result = append(result, res...)
+	return res.IPs(), nil
+}
 
-	// Create DNS64 Message with single question
-	msg = &dns.Msg{
-		MsgHdr: dns.MsgHdr{
-			Id:               dns.Id(),
-			RecursionDesired: true,
-		},
-		Question: []dns.Question{{Name: dns.Fqdn(hostFQDN), Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}},
+// LookupHost queries nameserver for the A & AAAA records of hostFQDN
+func LookupHost(ctx context.Context, nameserver, hostFQDN string, opts DNSOptions) DNSLookupResult {
+	return DNSLookupResult{
+		A:    QueryDNS(ctx, nameserver, hostFQDN, dns.TypeA, opts),
+		AAAA: QueryDNS(ctx, nameserver, hostFQDN, dns.TypeAAAA, opts),
 	}
+}
 
-	// Send question and add resolved ips to result
-	res, err = getIPFromDNSQuery(msg, nameserver)
+// LookupAddr queries nameserver for the PTR records of ip. Answers hold the names ip maps to
+func LookupAddr(ctx context.Context, nameserver, ip string, opts DNSOptions) DNSQueryResult {
+	arpa, err := dns.ReverseAddr(ip)
 	if err != nil {
-		return nil, err
+		return DNSQueryResult{Name: ip, Type: dns.TypeToString[dns.TypePTR], Err: err}
 	}
-	result = append(result, res...)
+	return QueryDNS(ctx, nameserver, arpa, dns.TypePTR, opts)
+}
 
-	return result, err
+// LookupSRV queries nameserver for the SRV records of _service._proto.name
+func LookupSRV(ctx context.Context, nameserver, service, proto, name string, opts DNSOptions) DNSQueryResult {
+	return QueryDNS(ctx, nameserver, fmt.Sprintf("_%s._%s.%s", service, proto, name), dns.TypeSRV, opts)
 }
 
-func getIPFromDNSQuery(msg *dns.Msg, nameserver string) ([]string, error) {
-	result := []string{}
-	// Send question to nameserver and wait for answer
-	in, err := dns.Exchange(msg, nameserver)
-	if err != nil {
-		return nil, err
+// QueryDNS sends a query for name of type qtype to nameserver. Timed out queries are
+// retried opts.Retries times. Truncated UDP responses are retried over TCP
+func QueryDNS(ctx context.Context, nameserver, name string, qtype uint16, opts DNSOptions) DNSQueryResult {
+	result := DNSQueryResult{Name: dns.Fqdn(name), Type: dns.TypeToString[qtype]}
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	if opts.UDPSize > 0 {
+		msg.SetEdns0(opts.UDPSize, false)
 	}
-
-	if in != nil && in.Rcode != dns.RcodeSuccess {
+	client := &dns.Client{Net: "udp", Timeout: dnsTimeout, UDPSize: opts.UDPSize}
+	var in *dns.Msg
+	for result.Attempts = 1; ; result.Attempts++ {
+		in, result.Latency, result.LocalAddr, result.Err = exchange(ctx, client, msg, nameserver)
+		if result.Err == nil && in.Truncated && client.Net == "udp" {
+			// Answer doesn't fit in the UDP buffer. Retry over TCP
+			result.Truncated = true
+			client.Net = "tcp"
+			continue
+		}
+		if result.Err == nil || ctx.Err() != nil || !isTimeout(result.Err) || result.Attempts > opts.Retries {
+			break
+		}
+	}
+	if result.Err != nil {
+		if ctx.Err() != nil {
+			result.Err = ctx.Err()
+		}
+		return result
+	}
+	result.Rcode = dns.RcodeToString[in.Rcode]
+	switch in.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		result.Err = ErrNXDomain
+		return result
+	default:
 		// Return error code
-		return result, errors.New(dns.RcodeToString[in.Rcode])
+		result.Err = errors.New(result.Rcode)
+		return result
 	}
-
-	// Fetch IP Addresses in DNS Answer and return
+	// Fetch records of the query type in the answer
 	for _, record := range in.Answer {
-		if t, ok := record.(*dns.A); ok {
-			result = append(result, t.A.String())
+		if record.Header().Rrtype != qtype {
+			continue
+		}
+		switch t := record.(type) {
+		case *dns.A:
+			result.Answers = append(result.Answers, t.A.String())
+		case *dns.AAAA:
+			result.Answers = append(result.Answers, t.AAAA.String())
+		case *dns.PTR:
+			result.Answers = append(result.Answers, t.Ptr)
+		case *dns.SRV:
+			result.Answers = append(result.Answers, net.JoinHostPort(t.Target, strconv.Itoa(int(t.Port))))
+			result.SRV = append(result.SRV, SRVRecord{Target: t.Target, Port: t.Port, Priority: t.Priority, Weight: t.Weight})
+		default:
+			result.Answers = append(result.Answers, record.String())
+		}
+	}
+	return result
+}
+
+// exchange sends msg to nameserver over a new connection & waits for the response until
+// the earlier of dnsTimeout or ctx deadline. Returns the local "ip:port" of the connection
+func exchange(ctx context.Context, client *dns.Client, msg *dns.Msg, nameserver string) (*dns.Msg, time.Duration, string, error) {
+	client.Timeout = time.Until(probeDeadline(ctx, dnsTimeout))
+	conn, err := client.DialContext(ctx, nameserver)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer conn.Close()
+	defer watchContext(ctx, conn)()
+	in, rtt, err := client.ExchangeWithConn(msg, conn)
+	return in, rtt, conn.LocalAddr().String(), err
+}
+
+// EDNSProbeResult describes the EDNS0 support of a nameserver
+type EDNSProbeResult struct {
+	Supported  bool   // Nameserver responded with an OPT record
+	ServerSize uint16 // UDP buffer size advertised by the nameserver
+	// MaxWorkingSize is the largest buffer size probed for which a response was received.
+	// Queries advertising larger sizes went unanswered, eg: dropped by a middlebox. 512 if
+	// EDNS0 isn't supported
+	MaxWorkingSize uint16
+	Failed         []uint16 // Buffer sizes for which no response was received
+	ResponseSize   int      // Size in bytes of the response received
+	LocalAddr      string   // Local ip:port the answered query was sent from
+}
+
+// ednsProbeSizes are the EDNS0 buffer sizes probed in descending order
+var ednsProbeSizes = []uint16{4096, DefaultEDNSBufferSize, 512}
+
+// ProbeEDNSBufferSize queries nameserver for the A record of name advertising decreasing
+// EDNS0 UDP buffer sizes. Returns an error if no response is received for any of the sizes.
+// The response is only as large as the answer for name, so loss of fragmented responses
+// larger than the path MTU isn't detected
+func ProbeEDNSBufferSize(ctx context.Context, nameserver, name string) (EDNSProbeResult, error) {
+	var result EDNSProbeResult
+	var lastErr error
+	for _, size := range ednsProbeSizes {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(name), dns.TypeA)
+		msg.SetEdns0(size, false)
+		client := &dns.Client{Net: "udp", Timeout: dnsTimeout, UDPSize: size}
+		in, _, localAddr, err := exchange(ctx, client, msg, nameserver)
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			lastErr = err
+			result.Failed = append(result.Failed, size)
+			continue
 		}
-		if t, ok := record.(*dns.AAAA); ok {
-			result = append(result, t.AAAA.String())
+		result.ResponseSize = in.Len()
+		result.LocalAddr = localAddr
+		// Nameservers that don't implement EDNS0 respond with FORMERR or without an OPT
+		// record. Their UDP responses are limited to 512 bytes whatever size is advertised
+		result.MaxWorkingSize = dns.MinMsgSize
+		if opt := in.IsEdns0(); opt != nil && in.Rcode != dns.RcodeFormatError {
+			result.Supported = true
+			result.ServerSize = opt.UDPSize()
+			result.MaxWorkingSize = size
 		}
+		return result, nil
 	}
-	return result, err
+	return result, fmt.Errorf("no response for any EDNS0 buffer size: %v", lastErr)
+}
+
+// isTimeout checks if err is a network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
@@ -1,11 +1,17 @@
 package netutils
 
 import (
+	"context"
+	"errors"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/miekg/dns"
 )
 
 func TestDNSLookupGoogle(t *testing.T) {
-	res, err := RunDNSLookupUsingCustomResolver("8.8.8.8:53", "www.google.com")
+	res, err := RunDNSLookupUsingCustomResolver(context.Background(), "8.8.8.8:53", "www.google.com")
 	if err != nil {
 		t.Errorf("Unable to resolve Google using Google DNS! Error:%v", err)
 	}
@@ -15,3 +21,153 @@ func TestDNSLookupGoogle(t *testing.T) {
 		t.Errorf("DNS resolution for www.google.com into ipv4 and ipv6 addresses failed with Google DNS")
 	}
 }
+
+// startTestDNSServer starts a DNS server on localhost serving over both UDP & TCP.
+// Returns the "ip:port" the server listens on
+func startTestDNSServer(t *testing.T, handler dns.HandlerFunc) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen on udp: %v", err)
+	}
+	addr := pc.LocalAddr().String()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Unable to listen on tcp: %v", err)
+	}
+	for _, server := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: l, Handler: handler}} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { server.Shutdown() })
+	}
+	return addr
+}
+
+func TestLookupHost(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		switch {
+		case q.Name == "missing.test.":
+			m.Rcode = dns.RcodeNameError
+		case q.Qtype == dns.TypeA:
+			rr, _ := dns.NewRR(q.Name + " 30 IN A 192.0.2.1")
+			m.Answer = append(m.Answer, rr)
+		}
+		// AAAA queries get an empty NOERROR response
+		w.WriteMsg(m)
+	})
+	res := LookupHost(context.Background(), addr, "v4only.test", DefaultDNSOptions())
+	if res.Err() != nil || len(res.A.Answers) != 1 || res.A.Answers[0] != "192.0.2.1" {
+		t.Errorf("Unexpected A result: %+v", res.A)
+	}
+	if res.AAAA.Err != nil || res.AAAA.Rcode != "NOERROR" || len(res.AAAA.Answers) != 0 {
+		t.Errorf("Unexpected AAAA result: %+v", res.AAAA)
+	}
+	if _, err := RunDNSLookupUsingCustomResolver(context.Background(), addr, "missing.test"); !errors.Is(err, ErrNXDomain) {
+		t.Errorf("Expected NXDOMAIN. Got: %v", err)
+	}
+}
+
+func TestLookupAddr(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if q := r.Question[0]; q.Qtype == dns.TypePTR && q.Name == "1.0.96.10.in-addr.arpa." {
+			rr, _ := dns.NewRR(q.Name + " 30 IN PTR kubernetes.default.svc.cluster.local.")
+			m.Answer = append(m.Answer, rr)
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+	res := LookupAddr(context.Background(), addr, "10.96.0.1", DefaultDNSOptions())
+	if res.Err != nil || len(res.Answers) != 1 || res.Answers[0] != "kubernetes.default.svc.cluster.local." {
+		t.Errorf("Unexpected PTR result: %+v", res)
+	}
+	if res := LookupAddr(context.Background(), addr, "not-an-ip", DefaultDNSOptions()); res.Err == nil {
+		t.Errorf("Expected error for invalid IP. Got: %+v", res)
+	}
+}
+
+func TestLookupSRV(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if q := r.Question[0]; q.Qtype == dns.TypeSRV && q.Name == "_http._tcp.web.default.svc.cluster.local." {
+			rr, _ := dns.NewRR(q.Name + " 30 IN SRV 0 100 80 web.default.svc.cluster.local.")
+			m.Answer = append(m.Answer, rr)
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+	res := LookupSRV(context.Background(), addr, "http", "tcp", "web.default.svc.cluster.local.", DefaultDNSOptions())
+	if res.Err != nil || len(res.SRV) != 1 || res.SRV[0].Port != 80 || res.SRV[0].Target != "web.default.svc.cluster.local." {
+		t.Errorf("Unexpected SRV result: %+v", res)
+	}
+	if len(res.Answers) != 1 || res.Answers[0] != "web.default.svc.cluster.local.:80" {
+		t.Errorf("Unexpected SRV answers: %v", res.Answers)
+	}
+}
+
+func TestQueryDNSTruncatedRetriesOverTCP(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if w.LocalAddr().Network() == "udp" {
+			m.Truncated = true
+		} else {
+			rr, _ := dns.NewRR(r.Question[0].Name + " 30 IN A 192.0.2.1")
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	})
+	res := QueryDNS(context.Background(), addr, "large.test", dns.TypeA, DefaultDNSOptions())
+	if res.Err != nil || !res.Truncated || res.Attempts != 2 || len(res.Answers) != 1 {
+		t.Errorf("Expected truncated response to be retried over TCP. Got: %+v", res)
+	}
+}
+
+func TestProbeEDNSBufferSize(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if opt := r.IsEdns0(); opt != nil {
+			if opt.UDPSize() > 1232 {
+				// Simulate responses to large buffers being dropped along the path
+				return
+			}
+			m.SetEdns0(1232, false)
+		}
+		w.WriteMsg(m)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := ProbeEDNSBufferSize(ctx, addr, "edns.test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !res.Supported || res.ServerSize != 1232 || res.MaxWorkingSize != 1232 || len(res.Failed) != 1 || res.ResponseSize == 0 {
+		t.Errorf("Unexpected EDNS probe result: %+v", res)
+	}
+}
+
+func TestProbeEDNSBufferSizeUnsupported(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeFormatError)
+		w.WriteMsg(m)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := ProbeEDNSBufferSize(ctx, addr, "edns.test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.Supported || res.MaxWorkingSize != 512 || len(res.Failed) != 0 {
+		t.Errorf("Expected a FORMERR to limit the working size to 512 bytes. Got: %+v", res)
+	}
+}
@@ -1,6 +1,7 @@
 package netutils
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
@@ -8,18 +9,24 @@ import (
 	"time"
 )
 
+const httpTimeout = 5 * time.Second
+
 // SendRecvHTTPMessage sends out a HTTP GET request to the url specified
 // add token to X-Auth-Token as a Bearer token if token is specified
 // Return body from GET response as part of body *[]byte
-func SendRecvHTTPMessage(url string, token string, body *[]byte) (int, error) {
+// The request is aborted after httpTimeout or when ctx is done
+func SendRecvHTTPMessage(ctx context.Context, url string, token string, body *[]byte) (int, error) {
 	// Transport Layer settings
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	// Create HTTP Client
-	client := &http.Client{Transport: tr, Timeout: time.Duration(5) * time.Second}
+	client := &http.Client{Transport: tr, Timeout: httpTimeout}
 	// Create GET request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return -1, err
+	}
 	// Add Authorization header if token specified
 	if token != "" {
 		req.Header.Add("Authorization", "Bearer "+token)
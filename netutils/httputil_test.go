@@ -1,6 +1,7 @@
 package netutils
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -18,7 +19,7 @@ func TestSendRecvHTTPMessageV4(t *testing.T) {
 	var body []byte
 	// Use Google IPv4 Address
 	url := "http://172.217.164.100:80"
-	responseCode, err := SendRecvHTTPMessage(url, "", &body)
+	responseCode, err := SendRecvHTTPMessage(context.Background(), url, "", &body)
 	if err != nil {
 		t.Errorf("Unable to fetch response Error: %v", err)
 	}
@@ -35,7 +36,7 @@ func TestSendRecvHTTPMessageV6(t *testing.T) {
 	// TODO: Use localtest server for unit testing
 	// Use www.google.com IPV6 address
 	url := fmt.Sprintf("http://%s", net.JoinHostPort("2607:f8b0:4005:804::2004", "80"))
-	responseCode, err := SendRecvHTTPMessage(url, "", &body)
+	responseCode, err := SendRecvHTTPMessage(context.Background(), url, "", &body)
 	if err != nil {
 		t.Errorf("Unable to fetch response Error: %v", err)
 	}
@@ -1,6 +1,7 @@
 package netutils
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
@@ -18,7 +19,7 @@ import (
 )
 
 const (
-	icmpTimeout        = 4
+	icmpTimeout        = 4 * time.Second
 	icmpMessagePrefix  = "K8SNETLOOK"
 	defaultPayloadSize = 84
 	icmpIDRandMin      = 5000
@@ -31,15 +32,17 @@ const (
 // returncode: 0 - no error. Echo reply received successfully
 //			   1 - Fragmentation required
 //             2 - got icmp but unknwon type
-func SendRecvICMPMessage(dstIP string, payloadSize int, dontFragment bool) (int, error) {
+// The reply is waited upon until the earlier of icmpTimeout or ctx deadline. Unexpected
+// replies are logged using logger
+func SendRecvICMPMessage(ctx context.Context, logger log.Logger, dstIP string, payloadSize int, dontFragment bool) (int, error) {
 	// Note: Does not handle IPv4 literal in IPv6. TODO later
 	ip := net.ParseIP(dstIP)
 	if ip.To4() != nil {
 		// IPv4
-		return sendRecvICMPMessageV4(dstIP, payloadSize, dontFragment)
+		return sendRecvICMPMessageV4(ctx, logger, dstIP, payloadSize, dontFragment)
 	}
 	// IPv6
-	return sendRecvICMPMessageV6(dstIP, payloadSize)
+	return sendRecvICMPMessageV6(ctx, logger, dstIP, payloadSize)
 
 }
 
@@ -48,7 +51,7 @@ func SendRecvICMPMessage(dstIP string, payloadSize int, dontFragment bool) (int,
 // returncode: 0 - no error. Echo reply received successfully
 //			   1 - Fragmentation required
 //             2 - got icmp but unknwon type
-func sendRecvICMPMessageV4(dstIP string, payloadSize int, dontFragment bool) (int, error) {
+func sendRecvICMPMessageV4(ctx context.Context, logger log.Logger, dstIP string, payloadSize int, dontFragment bool) (int, error) {
 	// If an additional payload size isn't specified, use default
 	if payloadSize < defaultPayloadSize {
 		payloadSize = defaultPayloadSize
@@ -67,12 +70,16 @@ func sendRecvICMPMessageV4(dstIP string, payloadSize int, dontFragment bool) (in
 	}
 
 	rb := make([]byte, payloadSize)
-	c.SetReadDeadline(time.Now().Add(time.Second * icmpTimeout))
+	c.SetReadDeadline(probeDeadline(ctx, icmpTimeout))
+	defer watchContext(ctx, c)()
 
 	// Read maxCountICMPReply packets
 	for tries := 0; tries < maxCountICMPReply; tries++ {
 		n, _, err := c.ReadFrom(rb)
 		if err != nil {
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
 			if err.(net.Error).Timeout() {
 				return -1, fmt.Errorf("ICMP timeout")
 			}
@@ -94,7 +101,7 @@ func sendRecvICMPMessageV4(dstIP string, payloadSize int, dontFragment bool) (in
 				// k8snetlook reply received
 				return 0, nil
 			}
-			log.Debug("    got echo reply but not for k8snellook packet. Continuing to read more icmp reply packets")
+			logger.Debug("    got echo reply but not for k8snellook packet. Continuing to read more icmp reply packets")
 		case ipv4.ICMPTypeDestinationUnreachable:
 			if rm.Code == layers.ICMPv4CodeFragmentationNeeded {
 				// log.Debug("   Fragmentation required, and DF flag set\n")
@@ -169,7 +176,7 @@ func sendICMPMessageV4(dstIP string, payloadSize int, dontfragment bool, icmpMes
 // returncode: 0 - no error. Echo reply received successfully
 //			   1 - Fragmentation required
 //             2 - got icmp but unknwon type
-func sendRecvICMPMessageV6(dstIP string, payloadSize int) (int, error) {
+func sendRecvICMPMessageV6(ctx context.Context, logger log.Logger, dstIP string, payloadSize int) (int, error) {
 	// Don't fragment is always set for IPv6. IPv6 packets cannot be fragmented
 	// Listen for ICMP reply on all IPs
 	c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
@@ -208,12 +215,16 @@ func sendRecvICMPMessageV6(dstIP string, payloadSize int) (int, error) {
 	}
 
 	rb := make([]byte, payloadSize)
-	c.SetReadDeadline(time.Now().Add(time.Second * icmpTimeout))
+	c.SetReadDeadline(probeDeadline(ctx, icmpTimeout))
+	defer watchContext(ctx, c)()
 
 	// Read maxCountICMPReply packets
 	for tries := 0; tries < maxCountICMPReply; tries++ {
 		n, _, err := c.ReadFrom(rb)
 		if err != nil {
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
 			if err.(net.Error).Timeout() {
 				return -1, fmt.Errorf("ICMP timeout")
 			}
@@ -235,12 +246,12 @@ func sendRecvICMPMessageV6(dstIP string, payloadSize int) (int, error) {
 				// k8snetlook reply received
 				return 0, nil
 			}
-			log.Debug("    got echo reply but not for k8snellook packet. Continuing to read more icmp reply packets")
+			logger.Debug("    got echo reply but not for k8snellook packet. Continuing to read more icmp reply packets")
 		case ipv6.ICMPTypePacketTooBig:
 			// log.Debug("   Fragmentation required, and DF flag set\n")
 			return 1, nil
 		default:
-			log.Debug("    got %+v; want echo reply\n", rm)
+			logger.Debug("    got %+v; want echo reply\n", rm)
 		}
 	}
 	// Got ICMP type but not an echo reply
@@ -1,11 +1,14 @@
 package netutils
 
 import (
+	"context"
 	"testing"
+
+	log "github.com/sarun87/k8snetlook/logutil"
 )
 
 func TestSendRcvICMPMessageSuccess(t *testing.T) {
-	ret, err := SendRecvICMPMessage("127.0.0.1", 64, true)
+	ret, err := SendRecvICMPMessage(context.Background(), log.New(log.ERROR), "127.0.0.1", 64, true)
 	if err != nil {
 		t.Errorf("ICMP reply expected from localhost. Received error: %s", err)
 		return
@@ -17,7 +20,7 @@ func TestSendRcvICMPMessageSuccess(t *testing.T) {
 
 func TestSendRcvICMPMessageFailure(t *testing.T) {
 	// Using arbitary IP for failure test
-	_, err := SendRecvICMPMessage("192.192.192.192", 64, true)
+	_, err := SendRecvICMPMessage(context.Background(), log.New(log.ERROR), "192.192.192.192", 64, true)
 	if err == nil {
 		t.Errorf("Expected ICMP to arbitary IP to fail with a timeout")
 	}
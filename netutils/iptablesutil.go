@@ -0,0 +1,127 @@
+package netutils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// IPTablesRule is a rule of a chain as printed by iptables-save
+type IPTablesRule struct {
+	Chain string
+	Args  []string // Arguments following "-A <chain>"
+}
+
+// Arg returns the value following the first occurrence of flag. Empty if not found
+func (r IPTablesRule) Arg(flag string) string {
+	for i := 0; i < len(r.Args)-1; i++ {
+		if r.Args[i] == flag {
+			return r.Args[i+1]
+		}
+	}
+	return ""
+}
+
+// Target returns the target the rule jumps to. Eg: ACCEPT, DNAT or a chain
+func (r IPTablesRule) Target() string {
+	if target := r.Arg("-j"); target != "" {
+		return target
+	}
+	return r.Arg("-g")
+}
+
+// IPTablesSave dumps table of the current netns using iptables-save or ip6tables-save.
+// The error wraps exec.ErrNotFound if the binary isn't installed
+func IPTablesSave(ctx context.Context, table string, ipv6 bool) (string, error) {
+	cmd := "iptables-save"
+	if ipv6 {
+		cmd = "ip6tables-save"
+	}
+	out, err := exec.CommandContext(ctx, cmd, "-t", table).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s -t %s failed: %w", cmd, table, err)
+	}
+	return string(out), nil
+}
+
+// ParseIPTablesSave parses the output of iptables-save for table. Returns the rules of
+// each chain in order. Chains without rules are included
+func ParseIPTablesSave(data, table string) (map[string][]IPTablesRule, error) {
+	chains := map[string][]IPTablesRule{}
+	found := false
+	inTable := false
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "*"):
+			inTable = line[1:] == table
+			found = found || inTable
+		case !inTable:
+		case line == "COMMIT":
+			inTable = false
+		case strings.HasPrefix(line, ":"):
+			// :CHAIN POLICY [packets:bytes]
+			if fields := strings.Fields(line[1:]); len(fields) > 0 {
+				if _, ok := chains[fields[0]]; !ok {
+					chains[fields[0]] = nil
+				}
+			}
+		case strings.HasPrefix(line, "-A "):
+			args, err := splitIPTablesArgs(line[len("-A "):])
+			if err != nil {
+				return nil, err
+			}
+			if len(args) == 0 {
+				continue
+			}
+			chains[args[0]] = append(chains[args[0]], IPTablesRule{Chain: args[0], Args: args[1:]})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+	return chains, nil
+}
+
+// splitIPTablesArgs splits a rule into arguments. Double quoted arguments such as comments
+// may contain spaces & escaped quotes
+func splitIPTablesArgs(line string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inQuotes, hasArg := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && inQuotes && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+		case c == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case c == ' ' && !inQuotes:
+			if hasArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				hasArg = false
+			}
+		default:
+			cur.WriteByte(c)
+			hasArg = true
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote in rule: %s", line)
+	}
+	if hasArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,52 @@
+package netutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testIPTablesSave = `# Generated by iptables-save v1.8.7 on Sat Oct 17 10:00:00 2026
+*filter
+:INPUT ACCEPT [0:0]
+-A INPUT -j KUBE-FIREWALL
+COMMIT
+*nat
+:PREROUTING ACCEPT [0:0]
+:KUBE-SERVICES - [0:0]
+:KUBE-MARK-MASQ - [0:0]
+-A PREROUTING -m comment --comment "kubernetes service portals" -j KUBE-SERVICES
+-A KUBE-SERVICES -d 10.96.0.10/32 -p udp -m comment --comment "kube-system/kube-dns:dns cluster IP" -m udp --dport 53 -j KUBE-SVC-TCOU7JCQXEZGVUNU
+-A KUBE-SERVICES -m comment --comment "quoted \"value\"" -g KUBE-NODEPORTS
+COMMIT
+`
+
+func TestParseIPTablesSave(t *testing.T) {
+	chains, err := ParseIPTablesSave(testIPTablesSave, "nat")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := chains["INPUT"]; ok {
+		t.Errorf("Chains of the filter table should not be included")
+	}
+	if rules, ok := chains["KUBE-MARK-MASQ"]; !ok || len(rules) != 0 {
+		t.Errorf("Expected empty KUBE-MARK-MASQ chain. Got: %v", rules)
+	}
+	rules := chains["KUBE-SERVICES"]
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 KUBE-SERVICES rules. Got: %v", rules)
+	}
+	expected := []string{"-d", "10.96.0.10/32", "-p", "udp", "-m", "comment", "--comment", "kube-system/kube-dns:dns cluster IP",
+		"-m", "udp", "--dport", "53", "-j", "KUBE-SVC-TCOU7JCQXEZGVUNU"}
+	if !reflect.DeepEqual(rules[0].Args, expected) {
+		t.Errorf("Unexpected args: %q", rules[0].Args)
+	}
+	if rules[0].Target() != "KUBE-SVC-TCOU7JCQXEZGVUNU" || rules[0].Arg("--dport") != "53" {
+		t.Errorf("Unexpected target/dport: %s %s", rules[0].Target(), rules[0].Arg("--dport"))
+	}
+	if rules[1].Arg("--comment") != `quoted "value"` || rules[1].Target() != "KUBE-NODEPORTS" {
+		t.Errorf("Unexpected comment/goto target: %q %s", rules[1].Arg("--comment"), rules[1].Target())
+	}
+	if _, err := ParseIPTablesSave(testIPTablesSave, "mangle"); err == nil {
+		t.Errorf("Expected error for missing table")
+	}
+}
@@ -0,0 +1,239 @@
+package netutils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Generic netlink commands & attributes of IPVS. See linux/ip_vs.h
+const (
+	ipvsGenlName    = "IPVS"
+	ipvsGenlVersion = 0x1
+
+	ipvsCmdGetService = 4
+	ipvsCmdGetDest    = 8
+
+	ipvsCmdAttrService = 1
+	ipvsCmdAttrDest    = 2
+
+	ipvsSvcAttrAF        = 1
+	ipvsSvcAttrProtocol  = 2
+	ipvsSvcAttrAddr      = 3
+	ipvsSvcAttrPort      = 4
+	ipvsSvcAttrFWMark    = 5
+	ipvsSvcAttrSchedName = 6
+
+	ipvsDestAttrAddr          = 1
+	ipvsDestAttrPort          = 2
+	ipvsDestAttrWeight        = 4
+	ipvsDestAttrActiveConns   = 7
+	ipvsDestAttrInactiveConns = 8
+	ipvsDestAttrAddrFamily    = 11
+)
+
+// IPVSService is an IPVS virtual service
+type IPVSService struct {
+	Address   string
+	Port      uint16
+	Protocol  string // TCP, UDP or SCTP
+	FWMark    uint32 // Set for firewall mark based services which have no address
+	Scheduler string
+	family    uint16
+	protocol  uint16
+}
+
+// IPVSDestination is a real server of an IPVS virtual service
+type IPVSDestination struct {
+	Address       string
+	Port          uint16
+	Weight        uint32
+	ActiveConns   uint32
+	InactiveConns uint32
+}
+
+// GetIPVSServices returns the IPVS virtual services of the current netns
+func GetIPVSServices() ([]IPVSService, error) {
+	msgs, err := ipvsRequest(ipvsCmdGetService, nil)
+	if err != nil {
+		return nil, err
+	}
+	var ret []IPVSService
+	for _, msg := range msgs {
+		attr, err := ipvsMessageAttr(msg, ipvsCmdAttrService)
+		if err != nil {
+			return nil, err
+		}
+		svc, err := parseIPVSService(attr)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, svc)
+	}
+	return ret, nil
+}
+
+// GetIPVSDestinations returns the real servers of the virtual service
+func GetIPVSDestinations(svc IPVSService) ([]IPVSDestination, error) {
+	msgs, err := ipvsRequest(ipvsCmdGetDest, serializeIPVSService(svc))
+	if err != nil {
+		return nil, err
+	}
+	var ret []IPVSDestination
+	for _, msg := range msgs {
+		attr, err := ipvsMessageAttr(msg, ipvsCmdAttrDest)
+		if err != nil {
+			return nil, err
+		}
+		dest, err := parseIPVSDestination(attr, svc.family)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, dest)
+	}
+	return ret, nil
+}
+
+// ipvsRequest sends a dump request for cmd to the IPVS generic netlink family
+func ipvsRequest(cmd uint8, attr *nl.RtAttr) ([][]byte, error) {
+	family, err := netlink.GenlFamilyGet(ipvsGenlName)
+	if err != nil {
+		return nil, fmt.Errorf("IPVS netlink family not found. ip_vs module may not be loaded: %v", err)
+	}
+	req := nl.NewNetlinkRequest(int(family.ID), unix.NLM_F_DUMP)
+	req.AddData(&nl.Genlmsg{Command: cmd, Version: ipvsGenlVersion})
+	if attr != nil {
+		req.AddData(attr)
+	}
+	return req.Execute(unix.NETLINK_GENERIC, 0)
+}
+
+// ipvsMessageAttr returns the value of the attribute attrType of a generic netlink message
+func ipvsMessageAttr(msg []byte, attrType uint16) ([]byte, error) {
+	// Skip the generic netlink header
+	if len(msg) < nl.SizeofGenlmsg {
+		return nil, fmt.Errorf("short IPVS netlink message")
+	}
+	attrs, err := nl.ParseRouteAttr(msg[nl.SizeofGenlmsg:])
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range attrs {
+		if attr.Attr.Type&nl.NLA_TYPE_MASK == attrType {
+			return attr.Value, nil
+		}
+	}
+	return nil, fmt.Errorf("IPVS netlink attribute %d not found", attrType)
+}
+
+// serializeIPVSService returns the nested attribute identifying the virtual service
+func serializeIPVSService(svc IPVSService) *nl.RtAttr {
+	attr := nl.NewRtAttr(ipvsCmdAttrService|unix.NLA_F_NESTED, nil)
+	attr.AddRtAttr(ipvsSvcAttrAF, nl.Uint16Attr(svc.family))
+	if svc.FWMark != 0 {
+		attr.AddRtAttr(ipvsSvcAttrFWMark, nl.Uint32Attr(svc.FWMark))
+		return attr
+	}
+	ip := net.ParseIP(svc.Address)
+	addr := make([]byte, net.IPv6len)
+	if svc.family == unix.AF_INET {
+		copy(addr, ip.To4())
+	} else {
+		copy(addr, ip.To16())
+	}
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, svc.Port)
+	attr.AddRtAttr(ipvsSvcAttrProtocol, nl.Uint16Attr(svc.protocol))
+	attr.AddRtAttr(ipvsSvcAttrAddr, addr)
+	attr.AddRtAttr(ipvsSvcAttrPort, port)
+	return attr
+}
+
+// parseIPVSService parses the nested service attributes of a netlink message
+func parseIPVSService(b []byte) (IPVSService, error) {
+	var svc IPVSService
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return svc, err
+	}
+	var addr []byte
+	for _, attr := range attrs {
+		switch attr.Attr.Type & nl.NLA_TYPE_MASK {
+		case ipvsSvcAttrAF:
+			svc.family = nl.NativeEndian().Uint16(attr.Value)
+		case ipvsSvcAttrProtocol:
+			svc.protocol = nl.NativeEndian().Uint16(attr.Value)
+			svc.Protocol = ipProtocolName(svc.protocol)
+		case ipvsSvcAttrAddr:
+			addr = attr.Value
+		case ipvsSvcAttrPort:
+			svc.Port = binary.BigEndian.Uint16(attr.Value)
+		case ipvsSvcAttrFWMark:
+			svc.FWMark = nl.NativeEndian().Uint32(attr.Value)
+		case ipvsSvcAttrSchedName:
+			svc.Scheduler = nl.BytesToString(attr.Value)
+		}
+	}
+	if svc.FWMark == 0 {
+		svc.Address = ipvsAddress(addr, svc.family)
+	}
+	return svc, nil
+}
+
+// parseIPVSDestination parses the nested destination attributes of a netlink message.
+// Destinations use the family of the service unless stated otherwise
+func parseIPVSDestination(b []byte, family uint16) (IPVSDestination, error) {
+	var dest IPVSDestination
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return dest, err
+	}
+	var addr []byte
+	for _, attr := range attrs {
+		switch attr.Attr.Type & nl.NLA_TYPE_MASK {
+		case ipvsDestAttrAddr:
+			addr = attr.Value
+		case ipvsDestAttrPort:
+			dest.Port = binary.BigEndian.Uint16(attr.Value)
+		case ipvsDestAttrWeight:
+			dest.Weight = nl.NativeEndian().Uint32(attr.Value)
+		case ipvsDestAttrActiveConns:
+			dest.ActiveConns = nl.NativeEndian().Uint32(attr.Value)
+		case ipvsDestAttrInactiveConns:
+			dest.InactiveConns = nl.NativeEndian().Uint32(attr.Value)
+		case ipvsDestAttrAddrFamily:
+			family = nl.NativeEndian().Uint16(attr.Value)
+		}
+	}
+	dest.Address = ipvsAddress(addr, family)
+	return dest, nil
+}
+
+// ipvsAddress converts the 16 byte address of an IPVS attribute to a string
+func ipvsAddress(addr []byte, family uint16) string {
+	if family == unix.AF_INET && len(addr) >= net.IPv4len {
+		return net.IP(addr[:net.IPv4len]).String()
+	}
+	if len(addr) >= net.IPv6len {
+		return net.IP(addr[:net.IPv6len]).String()
+	}
+	return ""
+}
+
+// ipProtocolName returns the name of the IP protocol number
+func ipProtocolName(protocol uint16) string {
+	switch protocol {
+	case syscall.IPPROTO_TCP:
+		return "TCP"
+	case syscall.IPPROTO_UDP:
+		return "UDP"
+	case unix.IPPROTO_SCTP:
+		return "SCTP"
+	}
+	return fmt.Sprintf("%d", protocol)
+}
@@ -0,0 +1,53 @@
+package netutils
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestIPVSServiceAttrs(t *testing.T) {
+	for _, svc := range []IPVSService{
+		{Address: "10.96.0.1", Port: 443, Protocol: "TCP", family: unix.AF_INET, protocol: unix.IPPROTO_TCP},
+		{Address: "fd00:10:96::a", Port: 53, Protocol: "UDP", family: unix.AF_INET6, protocol: unix.IPPROTO_UDP},
+	} {
+		attrs, err := nl.ParseRouteAttr(serializeIPVSService(svc).Serialize())
+		if err != nil || len(attrs) != 1 {
+			t.Fatalf("Unable to parse serialized service %+v: %v", svc, err)
+		}
+		parsed, err := parseIPVSService(attrs[0].Value)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(parsed, svc) {
+			t.Errorf("Expected %+v. Got: %+v", svc, parsed)
+		}
+	}
+}
+
+func TestParseIPVSDestination(t *testing.T) {
+	addr := make([]byte, 16)
+	copy(addr, []byte{10, 244, 1, 5})
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, 6443)
+	attr := nl.NewRtAttr(ipvsCmdAttrDest|unix.NLA_F_NESTED, nil)
+	attr.AddRtAttr(ipvsDestAttrAddr, addr)
+	attr.AddRtAttr(ipvsDestAttrPort, port)
+	attr.AddRtAttr(ipvsDestAttrWeight, nl.Uint32Attr(0))
+	attr.AddRtAttr(ipvsDestAttrActiveConns, nl.Uint32Attr(3))
+	attrs, err := nl.ParseRouteAttr(attr.Serialize())
+	if err != nil || len(attrs) != 1 {
+		t.Fatalf("Unable to parse serialized destination: %v", err)
+	}
+	dest, err := parseIPVSDestination(attrs[0].Value, unix.AF_INET)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := IPVSDestination{Address: "10.244.1.5", Port: 6443, ActiveConns: 3}
+	if dest != expected {
+		t.Errorf("Expected %+v. Got: %+v", expected, dest)
+	}
+}
@@ -0,0 +1,113 @@
+package netutils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// LinkInfo describes a network interface
+type LinkInfo struct {
+	Name      string
+	Index     int
+	Type      string // Eg: veth, bridge, openvswitch, vxlan, ipvlan
+	OperState string // Eg: up, down, lowerlayerdown, unknown
+	Up        bool   // Administratively up
+	Carrier   bool   // Lower layer is up. Eg: veth peer is up
+	MTU       int
+	// ParentIndex is the index of the peer for veths & the index of the parent link for
+	// ipvlan, macvlan & vlan links. The peer of a veth may be in another netns
+	ParentIndex int
+	// Master & MasterType describe the bridge or OVS datapath the link is a port of.
+	// Empty if the link isn't enslaved
+	Master     string
+	MasterType string
+	RxErrors   uint64
+	TxErrors   uint64
+	RxDropped  uint64
+	TxDropped  uint64
+}
+
+func (l LinkInfo) String() string {
+	flags := []string{l.Type, l.OperState}
+	if !l.Up {
+		flags = append(flags, "admin down")
+	}
+	if !l.Carrier {
+		flags = append(flags, "no carrier")
+	}
+	s := fmt.Sprintf("%s (index %d, %s, mtu %d)", l.Name, l.Index, strings.Join(flags, ", "), l.MTU)
+	if l.Master != "" {
+		s += fmt.Sprintf(" master %s (%s)", l.Master, l.MasterType)
+	}
+	return s
+}
+
+// Counters describes the error & drop counters of the link
+func (l LinkInfo) Counters() string {
+	return fmt.Sprintf("rx errors %d, rx dropped %d, tx errors %d, tx dropped %d", l.RxErrors, l.RxDropped, l.TxErrors, l.TxDropped)
+}
+
+// GetLinks returns the links of the network namespace specified by nsHandle.
+// Use netns.None() for current netns
+func GetLinks(nsHandle netns.NsHandle) ([]LinkInfo, error) {
+	handle, err := netlink.NewHandleAt(nsHandle)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open netlink socket: %v", err)
+	}
+	defer handle.Delete()
+	links, err := handle.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list links: %v", err)
+	}
+	var ret []LinkInfo
+	for _, link := range links {
+		ret = append(ret, newLinkInfo(handle, link))
+	}
+	return ret, nil
+}
+
+// GetLinkByIndex returns the link of index in the network namespace specified by nsHandle.
+// Use netns.None() for current netns
+func GetLinkByIndex(nsHandle netns.NsHandle, index int) (LinkInfo, error) {
+	handle, err := netlink.NewHandleAt(nsHandle)
+	if err != nil {
+		return LinkInfo{}, fmt.Errorf("unable to open netlink socket: %v", err)
+	}
+	defer handle.Delete()
+	link, err := handle.LinkByIndex(index)
+	if err != nil {
+		return LinkInfo{}, fmt.Errorf("link of index %d not found: %v", index, err)
+	}
+	return newLinkInfo(handle, link), nil
+}
+
+// newLinkInfo describes link. handle is used to look up the master of the link
+func newLinkInfo(handle *netlink.Handle, link netlink.Link) LinkInfo {
+	attrs := link.Attrs()
+	ret := LinkInfo{
+		Name:        attrs.Name,
+		Index:       attrs.Index,
+		Type:        link.Type(),
+		OperState:   attrs.OperState.String(),
+		Up:          attrs.RawFlags&unix.IFF_UP != 0,
+		Carrier:     attrs.RawFlags&unix.IFF_LOWER_UP != 0,
+		MTU:         attrs.MTU,
+		ParentIndex: attrs.ParentIndex,
+	}
+	if stats := attrs.Statistics; stats != nil {
+		ret.RxErrors, ret.TxErrors = stats.RxErrors, stats.TxErrors
+		ret.RxDropped, ret.TxDropped = stats.RxDropped, stats.TxDropped
+	}
+	if attrs.MasterIndex != 0 {
+		ret.Master = fmt.Sprintf("index %d", attrs.MasterIndex)
+		if master, err := handle.LinkByIndex(attrs.MasterIndex); err == nil {
+			ret.Master = master.Attrs().Name
+			ret.MasterType = master.Type()
+		}
+	}
+	return ret
+}
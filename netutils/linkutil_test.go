@@ -0,0 +1,35 @@
+package netutils
+
+import (
+	"testing"
+
+	"github.com/vishvananda/netns"
+)
+
+func TestGetLinksLoopback(t *testing.T) {
+	links, err := GetLinks(netns.None())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, link := range links {
+		if link.Name != "lo" {
+			continue
+		}
+		if !link.Up || link.Master != "" {
+			t.Errorf("Expected lo to be up & not enslaved. Got: %s", link)
+		}
+		byIndex, err := GetLinkByIndex(netns.None(), link.Index)
+		if err != nil || byIndex.Name != "lo" {
+			t.Errorf("Expected lo for index %d. Got: %s, error: %v", link.Index, byIndex, err)
+		}
+		return
+	}
+	t.Errorf("lo not found in %v", links)
+}
+
+func TestLinkInfoCounters(t *testing.T) {
+	link := LinkInfo{Name: "eth0", RxDropped: 3}
+	if got := link.Counters(); got != "rx errors 0, rx dropped 3, tx errors 0, tx dropped 0" {
+		t.Errorf("Unexpected counters: %q", got)
+	}
+}
@@ -0,0 +1,148 @@
+package netutils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// neighResolveTimeout is the time resolution is waited for. The kernel sends 3
+	// ARP requests or neighbor solicitations 1s apart by default before giving up.
+	// STALE entries are first kept in DELAY for 5s before being probed
+	neighResolveTimeout  = 8500 * time.Millisecond
+	neighPollInterval    = 100 * time.Millisecond
+	neighResolvePort     = 9 // discard
+	neighStateNone       = "NONE"
+	neighStateIncomplete = "INCOMPLETE"
+)
+
+// Neighbor describes the neighbor table (ARP or NDP) entry of an IP on a link
+type Neighbor struct {
+	IP        string
+	Interface string
+	State     string // One of NONE, INCOMPLETE, REACHABLE, STALE, DELAY, PROBE, FAILED, NOARP, PERMANENT
+	MAC       string // Empty unless resolved
+}
+
+// Resolved checks if the link layer address of the neighbor is known
+func (n Neighbor) Resolved() bool {
+	return n.MAC != "" && n.State != neighStateNone && n.State != neighStateIncomplete && n.State != "FAILED"
+}
+
+func (n Neighbor) String() string {
+	if n.MAC == "" {
+		return fmt.Sprintf("%s dev %s %s", n.IP, n.Interface, n.State)
+	}
+	return fmt.Sprintf("%s dev %s lladdr %s %s", n.IP, n.Interface, n.MAC, n.State)
+}
+
+// LookupNeighbor returns the neighbor table entry of ip on the link named linkName in the
+// current netns. State is NONE if there is no entry
+func LookupNeighbor(linkName, ip string) (Neighbor, error) {
+	ret := Neighbor{IP: ip, Interface: linkName, State: neighStateNone}
+	dst := net.ParseIP(ip)
+	if dst == nil {
+		return ret, fmt.Errorf("invalid IP %q", ip)
+	}
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		return ret, err
+	}
+	family := unix.AF_INET
+	if dst.To4() == nil {
+		family = unix.AF_INET6
+	}
+	neighs, err := netlink.NeighList(link.Attrs().Index, family)
+	if err != nil {
+		return ret, fmt.Errorf("unable to list neighbors of %s: %v", linkName, err)
+	}
+	for _, neigh := range neighs {
+		if !neigh.IP.Equal(dst) {
+			continue
+		}
+		ret.State = neighStateName(neigh.State)
+		if len(neigh.HardwareAddr) > 0 {
+			ret.MAC = neigh.HardwareAddr.String()
+		}
+		break
+	}
+	return ret, nil
+}
+
+// ResolveNeighbor triggers resolution of ip on the link named linkName by sending a UDP
+// datagram to it, which makes the kernel send an ARP request or IPv6 neighbor solicitation
+// unless the entry is valid. The entry is returned once resolution completes, eg: it is
+// REACHABLE or FAILED, or after the earlier of neighResolveTimeout or ctx deadline
+func ResolveNeighbor(ctx context.Context, linkName, ip string) (Neighbor, error) {
+	if _, err := SendUDPDatagram(ctx, ip, neighResolvePort, nil); err != nil {
+		return Neighbor{IP: ip, Interface: linkName, State: neighStateNone}, err
+	}
+	deadline := probeDeadline(ctx, neighResolveTimeout)
+	for {
+		neigh, err := LookupNeighbor(linkName, ip)
+		if err != nil || !neighResolving(neigh.State) {
+			return neigh, err
+		}
+		if time.Now().Add(neighPollInterval).After(deadline) {
+			return neigh, nil
+		}
+		select {
+		case <-ctx.Done():
+			return neigh, ctx.Err()
+		case <-time.After(neighPollInterval):
+		}
+	}
+}
+
+// neighResolving checks if the kernel may still change a neighbor in state. Entries the
+// datagram was sent to move from STALE to DELAY & PROBE until confirmed or failed
+func neighResolving(state string) bool {
+	switch state {
+	case neighStateNone, neighStateIncomplete, "STALE", "DELAY", "PROBE":
+		return true
+	}
+	return false
+}
+
+// LinkUsesNeighbors checks if the link named linkName resolves link layer addresses.
+// Point to point, tunnel & loopback links don't
+func LinkUsesNeighbors(linkName string) (bool, error) {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		return false, err
+	}
+	return link.Attrs().RawFlags&(unix.IFF_NOARP|unix.IFF_LOOPBACK|unix.IFF_POINTOPOINT) == 0, nil
+}
+
+// neighStateName returns the name of the NUD state as listed by 'ip neigh'
+func neighStateName(state int) string {
+	names := []struct {
+		flag int
+		name string
+	}{
+		{netlink.NUD_INCOMPLETE, neighStateIncomplete},
+		{netlink.NUD_REACHABLE, "REACHABLE"},
+		{netlink.NUD_STALE, "STALE"},
+		{netlink.NUD_DELAY, "DELAY"},
+		{netlink.NUD_PROBE, "PROBE"},
+		{netlink.NUD_FAILED, "FAILED"},
+		{netlink.NUD_NOARP, "NOARP"},
+		{netlink.NUD_PERMANENT, "PERMANENT"},
+	}
+	var ret []string
+	for _, n := range names {
+		if state&n.flag != 0 {
+			ret = append(ret, n.name)
+		}
+	}
+	if len(ret) == 0 {
+		return neighStateNone
+	}
+	return strings.Join(ret, ",")
+}
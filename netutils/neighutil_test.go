@@ -0,0 +1,69 @@
+package netutils
+
+import (
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestNeighStateName(t *testing.T) {
+	tests := map[int]string{
+		netlink.NUD_NONE:                          "NONE",
+		netlink.NUD_INCOMPLETE:                    "INCOMPLETE",
+		netlink.NUD_STALE:                         "STALE",
+		netlink.NUD_FAILED:                        "FAILED",
+		netlink.NUD_REACHABLE | netlink.NUD_NOARP: "REACHABLE,NOARP",
+	}
+	for state, expected := range tests {
+		if got := neighStateName(state); got != expected {
+			t.Errorf("State %#x: expected %s. Got: %s", state, expected, got)
+		}
+	}
+}
+
+func TestNeighborResolved(t *testing.T) {
+	tests := []struct {
+		neigh    Neighbor
+		resolved bool
+	}{
+		{Neighbor{State: "REACHABLE", MAC: "aa:bb:cc:dd:ee:ff"}, true},
+		{Neighbor{State: "STALE", MAC: "aa:bb:cc:dd:ee:ff"}, true},
+		{Neighbor{State: "FAILED"}, false},
+		{Neighbor{State: "INCOMPLETE"}, false},
+		{Neighbor{State: "NONE"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.neigh.Resolved(); got != tt.resolved {
+			t.Errorf("%s: expected resolved %v. Got: %v", tt.neigh, tt.resolved, got)
+		}
+	}
+}
+
+func TestNeighResolving(t *testing.T) {
+	tests := map[string]bool{
+		"NONE":            true,
+		"INCOMPLETE":      true,
+		"STALE":           true,
+		"DELAY":           true,
+		"PROBE":           true,
+		"REACHABLE":       false,
+		"FAILED":          false,
+		"PERMANENT":       false,
+		"REACHABLE,NOARP": false,
+	}
+	for state, expected := range tests {
+		if got := neighResolving(state); got != expected {
+			t.Errorf("%s: expected resolving %v. Got: %v", state, expected, got)
+		}
+	}
+}
+
+func TestLinkUsesNeighbors(t *testing.T) {
+	uses, err := LinkUsesNeighbors("lo")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if uses {
+		t.Errorf("Expected loopback not to use neighbor resolution")
+	}
+}
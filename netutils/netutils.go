@@ -9,11 +9,12 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
-// GetHostGatewayIP returns the IP of the default gw as listed in the route list
-func GetHostGatewayIP() (string, error) {
+// GetHostGatewayIP returns the IP of the default gw as listed in the route list. Failures
+// looking up the IPv4 default route are logged using logger
+func GetHostGatewayIP(logger log.Logger) (string, error) {
 	gwIP, err := getHostGatewayIPUsingFamily(unix.AF_INET)
 	if err != nil {
-		log.Debug("Error: %v", err)
+		logger.Debug("Error: %v", err)
 		// If we are here, there was a problem returning list v4 routes. Try v6 routes
 		gwIP, err = getHostGatewayIPUsingFamily(unix.AF_INET6)
 		if err != nil {
@@ -37,3 +38,20 @@ func getHostGatewayIPUsingFamily(family int) (string, error) {
 	}
 	return "", fmt.Errorf("unable to find a route with default gw")
 }
+
+// GetLinkIPs returns the IPs assigned to the link named name in the current netns
+func GetLinkIPs(name string) ([]string, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, addr := range addrs {
+		ret = append(ret, addr.IP.String())
+	}
+	return ret, nil
+}
@@ -1,6 +1,7 @@
 package netutils
 
 import (
+	"context"
 	"net"
 
 	log "github.com/sarun87/k8snetlook/logutil"
@@ -13,21 +14,25 @@ const (
 )
 
 // PMTUProbeToDestIP runs ICMP pings to destination with varying payload size
-// and returns the highest MTU that works. Currently works for IPv4 only
-func PMTUProbeToDestIP(dstIP string) (int, error) {
+// and returns the highest MTU that works. Currently works for IPv4 only. Probes are
+// logged using logger
+func PMTUProbeToDestIP(ctx context.Context, logger log.Logger, dstIP string) (int, error) {
 	var maxOkMTU int
 	minPayloadSize, maxPayloadSize := (icmpHeaderSize + ipHeaderSize), (maxMTUSize - icmpHeaderSize - ipHeaderSize)
 
-	res, err := SendRecvICMPMessage(dstIP, minPayloadSize, true)
+	res, err := SendRecvICMPMessage(ctx, logger, dstIP, minPayloadSize, true)
 	if err != nil || res == 1 {
 		return -1, err
 	}
 	maxOkMTU = minPayloadSize
 	// Use binary search to check for working mtu
 	for minPayloadSize <= maxPayloadSize {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
 		midPayloadSize := (minPayloadSize + maxPayloadSize) / 2
-		log.Debug("Trying with mtu size:%d\n", midPayloadSize)
-		ret, err := SendRecvICMPMessage(dstIP, midPayloadSize, true)
+		logger.Debug("Trying with mtu size:%d\n", midPayloadSize)
+		ret, err := SendRecvICMPMessage(ctx, logger, dstIP, midPayloadSize, true)
 		if err != nil {
 			//fmt.Println("Received error:", err)
 			if e, ok := err.(*net.OpError); ok {
@@ -48,7 +53,7 @@ func PMTUProbeToDestIP(dstIP string) (int, error) {
 			maxPayloadSize = midPayloadSize - 1
 		} else {
 			// successful icmp response. Go higher
-			log.Debug("  got reflection from %s with payload: %d\n", dstIP, midPayloadSize)
+			logger.Debug("  got reflection from %s with payload: %d\n", dstIP, midPayloadSize)
 			minPayloadSize = midPayloadSize + 1
 			maxOkMTU = midPayloadSize
 		}
@@ -0,0 +1,281 @@
+package netutils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// RouteInfo describes the route the kernel picks for packets sent to a destination
+type RouteInfo struct {
+	Dst       string
+	Type      string // One of unicast, local, blackhole, unreachable, prohibit, throw...
+	Table     int
+	Rule      string // Policy routing rule that led to Table. Empty if unknown
+	Interface string // Output interface. Empty for blackhole, unreachable & prohibit routes
+	LinkIndex int
+	Src       string // Source IP picked for locally generated packets
+	Gw        string // Next hop. Empty for directly connected destinations
+	NextHops  int    // Next hops of the matching route. More than 1 for ECMP routes
+	// PeerIndex is the index of the peer of the output interface in the other netns
+	// if the output interface is a veth
+	PeerIndex int
+}
+
+// Unroutable checks if packets to the destination are dropped by the route
+func (r RouteInfo) Unroutable() bool {
+	return r.Type == "blackhole" || r.Type == "unreachable" || r.Type == "prohibit"
+}
+
+func (r RouteInfo) String() string {
+	if r.Unroutable() {
+		return fmt.Sprintf("%s %s table %s rule %q", r.Type, r.Dst, RouteTableName(r.Table), r.Rule)
+	}
+	s := fmt.Sprintf("%s %s", r.Type, r.Dst)
+	if r.Gw != "" {
+		s += " via " + r.Gw
+	}
+	s += fmt.Sprintf(" dev %s src %s table %s", r.Interface, r.Src, RouteTableName(r.Table))
+	if r.Rule != "" {
+		s += fmt.Sprintf(" rule %q", r.Rule)
+	}
+	if r.NextHops > 1 {
+		s += fmt.Sprintf(" ecmp %d next hops", r.NextHops)
+	}
+	return s
+}
+
+// GetRoute looks up the route to dst in the network namespace specified by nsHandle the
+// same way as 'ip route get'. The policy routing rule that selected the table is found by
+// matching the rules of the netns. Routes of type blackhole, unreachable & prohibit are
+// returned without an error. Use netns.None() for current netns
+func GetRoute(nsHandle netns.NsHandle, dst string) (RouteInfo, error) {
+	ret := RouteInfo{Dst: dst}
+	ip := net.ParseIP(dst)
+	if ip == nil {
+		return ret, fmt.Errorf("invalid IP %q", dst)
+	}
+	handle, err := netlink.NewHandleAt(nsHandle)
+	if err != nil {
+		return ret, fmt.Errorf("unable to open netlink socket: %v", err)
+	}
+	defer handle.Delete()
+
+	family := unix.AF_INET
+	if ip.To4() == nil {
+		family = unix.AF_INET6
+	}
+	rules, err := handle.RuleList(family)
+	if err != nil {
+		return ret, fmt.Errorf("unable to list rules: %v", err)
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+
+	routes, err := handle.RouteGet(ip)
+	if err != nil {
+		if !isUnroutableErr(err) {
+			return ret, err
+		}
+		// The kernel doesn't return the route dropping the packets. Find it by looking up
+		// the tables of the matching rules in order
+		rule, route, found := lookupRules(handle, family, rules, ip, nil, "")
+		if !found {
+			return ret, err
+		}
+		ret.Type = routeTypeName(route.Type)
+		ret.Table = rule.Table
+		ret.Rule = formatRule(rule)
+		if !ret.Unroutable() {
+			return ret, err
+		}
+		return ret, nil
+	}
+	if len(routes) == 0 {
+		return ret, fmt.Errorf("no route to %s", dst)
+	}
+	route := routes[0]
+	ret.Type = routeTypeName(route.Type)
+	ret.Table = route.Table
+	ret.LinkIndex = route.LinkIndex
+	if route.Src != nil {
+		ret.Src = route.Src.String()
+	}
+	if route.Gw != nil {
+		ret.Gw = route.Gw.String()
+	}
+	if link, err := handle.LinkByIndex(route.LinkIndex); err == nil {
+		ret.Interface = link.Attrs().Name
+		if link.Type() == "veth" {
+			ret.PeerIndex = link.Attrs().ParentIndex
+		}
+	}
+	// The kernel reports the main table for routes of the local table unless there are
+	// custom rules since both tables are merged. The table is found using the rules
+	ret.NextHops = 1
+	if rule, tableRoute, found := lookupRules(handle, family, rules, ip, route.Src, ret.Interface); found {
+		ret.Table = rule.Table
+		ret.Rule = formatRule(rule)
+		// RouteGet returns the next hop picked for the flow. ECMP next hops are listed
+		// by the route of the table
+		if len(tableRoute.MultiPath) > 1 {
+			ret.NextHops = len(tableRoute.MultiPath)
+		}
+	}
+	return ret, nil
+}
+
+// GetMasterIndex returns the index of the bridge or OVS datapath the link with the index
+// is a port of in the network namespace specified by nsHandle. Returns 0 if the link isn't
+// enslaved. Use netns.None() for current netns
+func GetMasterIndex(nsHandle netns.NsHandle, index int) (int, error) {
+	handle, err := netlink.NewHandleAt(nsHandle)
+	if err != nil {
+		return 0, fmt.Errorf("unable to open netlink socket: %v", err)
+	}
+	defer handle.Delete()
+	link, err := handle.LinkByIndex(index)
+	if err != nil {
+		return 0, err
+	}
+	return link.Attrs().MasterIndex, nil
+}
+
+// lookupRules returns the first of the rules matching dst, src & oif whose table has a
+// route to dst along with the route. Tables are looked up the same way as the kernel
+// except that only the prefix of routes is considered
+func lookupRules(handle *netlink.Handle, family int, rules []netlink.Rule, dst, src net.IP, oif string) (netlink.Rule, netlink.Route, bool) {
+	for _, rule := range rules {
+		if rule.Table <= 0 || !ruleMatches(rule, dst, src, oif) {
+			continue
+		}
+		routes, err := handle.RouteListFiltered(family, &netlink.Route{Table: rule.Table}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			continue
+		}
+		// Throw routes continue the lookup with the next rule
+		if route, found := longestPrefixRoute(routes, dst); found && route.Type != unix.RTN_THROW {
+			return rule, route, true
+		}
+	}
+	return netlink.Rule{}, netlink.Route{}, false
+}
+
+// isUnroutableErr checks if err is returned by the kernel for destinations without a
+// route or matching a blackhole, unreachable or prohibit route
+func isUnroutableErr(err error) bool {
+	return errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EHOSTUNREACH) ||
+		errors.Is(err, unix.ENETUNREACH) || errors.Is(err, unix.EACCES)
+}
+
+// ruleMatches checks if the selectors of rule match locally generated packets sent to dst
+// from src out of oif. Selectors that are unknown, ie: nil src or empty oif, match any value.
+// Packets are assumed to be unmarked
+func ruleMatches(rule netlink.Rule, dst, src net.IP, oif string) bool {
+	match := (rule.Dst == nil || rule.Dst.Contains(dst)) &&
+		(rule.Src == nil || src == nil || rule.Src.Contains(src)) &&
+		(rule.Mark <= 0) &&
+		// Locally generated packets have the loopback device as input interface
+		(rule.IifName == "" || rule.IifName == "lo") &&
+		(rule.OifName == "" || oif == "" || rule.OifName == oif)
+	if rule.Invert {
+		return !match
+	}
+	return match
+}
+
+// longestPrefixRoute returns the route with the longest prefix containing dst. Routes
+// without a destination are default routes
+func longestPrefixRoute(routes []netlink.Route, dst net.IP) (netlink.Route, bool) {
+	best, bestLen, found := netlink.Route{}, -1, false
+	for _, route := range routes {
+		prefixLen := 0
+		if route.Dst != nil {
+			if !route.Dst.Contains(dst) {
+				continue
+			}
+			prefixLen, _ = route.Dst.Mask.Size()
+		}
+		if prefixLen > bestLen {
+			best, bestLen, found = route, prefixLen, true
+		}
+	}
+	return best, found
+}
+
+// formatRule describes rule the way 'ip rule' lists it. Eg: "32766: from all lookup main"
+func formatRule(rule netlink.Rule) string {
+	var parts []string
+	if rule.Invert {
+		parts = append(parts, "not")
+	}
+	from := "all"
+	if rule.Src != nil {
+		from = rule.Src.String()
+	}
+	parts = append(parts, "from", from)
+	if rule.Dst != nil {
+		parts = append(parts, "to", rule.Dst.String())
+	}
+	if rule.Mark > 0 {
+		parts = append(parts, fmt.Sprintf("fwmark %#x", rule.Mark))
+	}
+	if rule.IifName != "" {
+		parts = append(parts, "iif", rule.IifName)
+	}
+	if rule.OifName != "" {
+		parts = append(parts, "oif", rule.OifName)
+	}
+	if rule.Table > 0 {
+		parts = append(parts, "lookup", RouteTableName(rule.Table))
+	}
+	// The priority attribute is omitted for the rule of priority 0
+	priority := rule.Priority
+	if priority < 0 {
+		priority = 0
+	}
+	return fmt.Sprintf("%d: %s", priority, strings.Join(parts, " "))
+}
+
+// RouteTableName returns the name of the reserved routing tables or the table number
+func RouteTableName(table int) string {
+	switch table {
+	case unix.RT_TABLE_MAIN:
+		return "main"
+	case unix.RT_TABLE_LOCAL:
+		return "local"
+	case unix.RT_TABLE_DEFAULT:
+		return "default"
+	}
+	return fmt.Sprintf("%d", table)
+}
+
+// routeTypeName returns the name of the route type as listed by 'ip route'
+func routeTypeName(routeType int) string {
+	switch routeType {
+	case unix.RTN_UNICAST:
+		return "unicast"
+	case unix.RTN_LOCAL:
+		return "local"
+	case unix.RTN_BROADCAST:
+		return "broadcast"
+	case unix.RTN_ANYCAST:
+		return "anycast"
+	case unix.RTN_MULTICAST:
+		return "multicast"
+	case unix.RTN_BLACKHOLE:
+		return "blackhole"
+	case unix.RTN_UNREACHABLE:
+		return "unreachable"
+	case unix.RTN_PROHIBIT:
+		return "prohibit"
+	case unix.RTN_THROW:
+		return "throw"
+	}
+	return fmt.Sprintf("%d", routeType)
+}
@@ -0,0 +1,88 @@
+package netutils
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Invalid CIDR %s: %v", cidr, err)
+	}
+	return ipNet
+}
+
+func TestGetRouteLoopback(t *testing.T) {
+	route, err := GetRoute(netns.None(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if route.Type != "local" || route.Interface != "lo" || RouteTableName(route.Table) != "local" {
+		t.Errorf("Expected local route via lo in local table. Got: %s", route)
+	}
+	if route.Rule != "0: from all lookup local" {
+		t.Errorf("Expected local rule to be matched. Got: %q", route.Rule)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	dst := net.ParseIP("10.244.2.5")
+	src := net.ParseIP("10.244.1.7")
+	rule := func(modify func(r *netlink.Rule)) netlink.Rule {
+		r := netlink.NewRule()
+		r.Table = 100
+		modify(r)
+		return *r
+	}
+	tests := []struct {
+		name  string
+		rule  netlink.Rule
+		match bool
+	}{
+		{"all", rule(func(r *netlink.Rule) {}), true},
+		{"to matching cidr", rule(func(r *netlink.Rule) { r.Dst = mustParseCIDR(t, "10.244.0.0/16") }), true},
+		{"to other cidr", rule(func(r *netlink.Rule) { r.Dst = mustParseCIDR(t, "10.96.0.0/12") }), false},
+		{"from other cidr", rule(func(r *netlink.Rule) { r.Src = mustParseCIDR(t, "192.168.0.0/16") }), false},
+		{"not from other cidr", rule(func(r *netlink.Rule) { r.Src = mustParseCIDR(t, "192.168.0.0/16"); r.Invert = true }), true},
+		{"fwmark", rule(func(r *netlink.Rule) { r.Mark = 0x4000 }), false},
+		{"iif lo", rule(func(r *netlink.Rule) { r.IifName = "lo" }), true},
+		{"iif eth0", rule(func(r *netlink.Rule) { r.IifName = "eth0" }), false},
+		{"oif eth1", rule(func(r *netlink.Rule) { r.OifName = "eth1" }), false},
+	}
+	for _, tt := range tests {
+		if match := ruleMatches(tt.rule, dst, src, "eth0"); match != tt.match {
+			t.Errorf("%s: expected match %v. Got: %v", tt.name, tt.match, match)
+		}
+	}
+}
+
+func TestLongestPrefixRoute(t *testing.T) {
+	routes := []netlink.Route{
+		{Gw: net.ParseIP("192.168.1.1")},
+		{Dst: mustParseCIDR(t, "10.244.0.0/16"), LinkIndex: 2},
+		{Dst: mustParseCIDR(t, "10.244.2.0/24"), LinkIndex: 3},
+	}
+	if route, found := longestPrefixRoute(routes, net.ParseIP("10.244.2.5")); !found || route.LinkIndex != 3 {
+		t.Errorf("Expected /24 route. Got: %+v", route)
+	}
+	if route, found := longestPrefixRoute(routes, net.ParseIP("8.8.8.8")); !found || route.Gw == nil {
+		t.Errorf("Expected default route. Got: %+v", route)
+	}
+	if _, found := longestPrefixRoute(routes[1:], net.ParseIP("8.8.8.8")); found {
+		t.Errorf("Expected no route without a default route")
+	}
+}
+
+func TestFormatRule(t *testing.T) {
+	rule := netlink.NewRule()
+	rule.Priority = 100
+	rule.Src = mustParseCIDR(t, "10.244.1.0/24")
+	rule.Table = 200
+	if got := formatRule(*rule); got != "100: from 10.244.1.0/24 lookup 200" {
+		t.Errorf("Unexpected rule description: %q", got)
+	}
+}
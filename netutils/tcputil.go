@@ -0,0 +1,60 @@
+package netutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const tcpConnectTimeout = 4 * time.Second
+
+// TCP connect states
+const (
+	TCPConnected   = "connected"   // 3-way handshake completed
+	TCPRefused     = "refused"     // RST received. Host reachable but nothing listening on port
+	TCPTimeout     = "timeout"     // No response. SYN or SYN-ACK likely dropped
+	TCPUnreachable = "unreachable" // ICMP host/network unreachable received
+)
+
+// TCPConnectResult describes the outcome of a TCP connect attempt
+type TCPConnectResult struct {
+	State     string        // One of TCPConnected, TCPRefused, TCPTimeout, TCPUnreachable
+	RTT       time.Duration // Time taken to connect or to fail
+	LocalAddr string        // Local ip:port used for the connection, if connected
+}
+
+// TCPConnect opens a TCP connection to ip:port & closes it right away.
+// The connect is waited upon until the earlier of tcpConnectTimeout or ctx deadline.
+// An error is returned only if the outcome could not be determined
+func TCPConnect(ctx context.Context, ip string, port int32) (TCPConnectResult, error) {
+	var result TCPConnectResult
+	dialer := net.Dialer{Deadline: probeDeadline(ctx, tcpConnectTimeout)}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(int(port))))
+	result.RTT = time.Since(start)
+	if err == nil {
+		result.State = TCPConnected
+		result.LocalAddr = conn.LocalAddr().String()
+		conn.Close()
+		return result, nil
+	}
+	if ctx.Err() != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return result, ctx.Err()
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		result.State = TCPRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		result.State = TCPUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		result.State = TCPTimeout
+	default:
+		return result, fmt.Errorf("unable to connect to %s:%d: %v", ip, port, err)
+	}
+	return result, nil
+}
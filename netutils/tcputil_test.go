@@ -0,0 +1,48 @@
+package netutils
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func listenLocalTCP(t *testing.T) (*net.TCPListener, int32) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Unable to listen on localhost: %v", err)
+	}
+	return l, int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestTCPConnectConnected(t *testing.T) {
+	l, port := listenLocalTCP(t)
+	defer l.Close()
+	res, err := TCPConnect(context.Background(), "127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.State != TCPConnected || res.LocalAddr == "" {
+		t.Errorf("Expected connection to succeed. Got: %+v", res)
+	}
+}
+
+func TestTCPConnectRefused(t *testing.T) {
+	// Listen & close to find a port that nothing is listening on
+	l, port := listenLocalTCP(t)
+	l.Close()
+	res, err := TCPConnect(context.Background(), "127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.State != TCPRefused {
+		t.Errorf("Expected connection to be refused. Got: %+v", res)
+	}
+}
+
+func TestTCPConnectCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := TCPConnect(ctx, "192.192.192.192", 80); err != context.Canceled {
+		t.Errorf("Expected context.Canceled. Got: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package netutils
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	udpWriteTimeout = 1 * time.Second
+	udpReplyTimeout = 2 * time.Second
+	udpMaxReplySize = 4096
+)
+
+// UDP probe states
+const (
+	UDPAnswered     = "answered"     // Reply received
+	UDPUnreachable  = "unreachable"  // ICMP port/host unreachable received. Definite failure
+	UDPInconclusive = "inconclusive" // No reply. Payload ignored by service or packets dropped
+)
+
+// UDPProbeResult describes the outcome of a UDP probe
+type UDPProbeResult struct {
+	State     string        // One of UDPAnswered, UDPUnreachable, UDPInconclusive
+	RTT       time.Duration // Time taken for the reply or the ICMP error
+	LocalAddr string        // Local ip:port the probe was sent from
+	Reply     []byte        // Reply payload if answered
+}
+
+// SendUDPDatagram sends a single UDP datagram with payload to ip:port.
+// Returns the local "ip:port" the datagram was sent from
+func SendUDPDatagram(ctx context.Context, ip string, port int32, payload []byte) (string, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(ip, strconv.Itoa(int(port))))
+	if err != nil {
+		return "", fmt.Errorf("unable to open udp socket: %v", err)
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(probeDeadline(ctx, udpWriteTimeout))
+	if _, err := conn.Write(payload); err != nil {
+		return "", fmt.Errorf("unable to send udp datagram to %s:%d: %v", ip, port, err)
+	}
+	return conn.LocalAddr().String(), nil
+}
+
+// SendRecvUDPProbe sends payload to ip:port & waits for a reply until the earlier of
+// udpReplyTimeout or ctx deadline. A connected udp socket is used so that ICMP
+// port unreachable errors are reported by the kernel on read as ECONNREFUSED
+func SendRecvUDPProbe(ctx context.Context, ip string, port int32, payload []byte) (UDPProbeResult, error) {
+	var result UDPProbeResult
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(ip, strconv.Itoa(int(port))))
+	if err != nil {
+		return result, fmt.Errorf("unable to open udp socket: %v", err)
+	}
+	defer conn.Close()
+	defer watchContext(ctx, conn)()
+	result.LocalAddr = conn.LocalAddr().String()
+
+	start := time.Now()
+	conn.SetWriteDeadline(probeDeadline(ctx, udpWriteTimeout))
+	if _, err := conn.Write(payload); err != nil {
+		return result, fmt.Errorf("unable to send udp probe to %s:%d: %v", ip, port, err)
+	}
+	conn.SetReadDeadline(probeDeadline(ctx, udpReplyTimeout))
+	rb := make([]byte, udpMaxReplySize)
+	n, err := conn.Read(rb)
+	result.RTT = time.Since(start)
+	if err == nil {
+		result.State = UDPAnswered
+		result.Reply = rb[:n]
+		return result, nil
+	}
+	if ctx.Err() != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return result, ctx.Err()
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		result.State = UDPUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		result.State = UDPInconclusive
+	default:
+		return result, fmt.Errorf("unable to read udp reply from %s:%d: %v", ip, port, err)
+	}
+	return result, nil
+}
+
+// DNSProbePayload returns a DNS A query for name to be used as the payload of a UDP probe
+func DNSProbePayload(name string) ([]byte, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	return msg.Pack()
+}
+
+// ParseHexPayload decodes a hex encoded payload. Whitespace & ':' separators are ignored
+// Eg: "de:ad:be:ef" or "deadbeef"
+func ParseHexPayload(s string) ([]byte, error) {
+	s = strings.NewReplacer(":", "", " ", "", "\t", "", "\n", "").Replace(s)
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+	payload, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex payload: %v", err)
+	}
+	return payload, nil
+}
@@ -0,0 +1,65 @@
+package netutils
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+)
+
+func TestSendRecvUDPProbeAnswered(t *testing.T) {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Unable to listen on localhost: %v", err)
+	}
+	defer pc.Close()
+	// Echo server
+	go func() {
+		b := make([]byte, 512)
+		n, addr, err := pc.ReadFrom(b)
+		if err == nil {
+			pc.WriteTo(b[:n], addr)
+		}
+	}()
+	port := int32(pc.LocalAddr().(*net.UDPAddr).Port)
+	res, err := SendRecvUDPProbe(context.Background(), "127.0.0.1", port, []byte("k8snetlook"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.State != UDPAnswered || !bytes.Equal(res.Reply, []byte("k8snetlook")) {
+		t.Errorf("Expected probe to be answered. Got: %+v", res)
+	}
+}
+
+func TestSendRecvUDPProbeUnreachable(t *testing.T) {
+	// Listen & close to find a port that nothing is listening on
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Unable to listen on localhost: %v", err)
+	}
+	port := int32(pc.LocalAddr().(*net.UDPAddr).Port)
+	pc.Close()
+	res, err := SendRecvUDPProbe(context.Background(), "127.0.0.1", port, []byte("k8snetlook"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.State != UDPUnreachable {
+		t.Errorf("Expected port unreachable. Got: %+v", res)
+	}
+}
+
+func TestParseHexPayload(t *testing.T) {
+	for _, s := range []string{"deadbeef", "de:ad:be:ef", "0xDEADBEEF", "de ad be ef"} {
+		payload, err := ParseHexPayload(s)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", s, err)
+			continue
+		}
+		if !bytes.Equal(payload, []byte{0xde, 0xad, 0xbe, 0xef}) {
+			t.Errorf("Unexpected payload for %q: %x", s, payload)
+		}
+	}
+	if _, err := ParseHexPayload("xyz"); err == nil {
+		t.Errorf("Expected error parsing invalid hex")
+	}
+}